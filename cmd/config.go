@@ -0,0 +1,217 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dakaraj/gatling-to-influxdb/config"
+	l "github.com/dakaraj/gatling-to-influxdb/logger"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// envPrefix is a prefix of environment variables overriding flags,
+// e.g. G2I_TEST_ID overrides --test-id
+const envPrefix = "G2I_"
+
+// secretFlags are flags which values can also be read from files
+// provided by flags with "-file" suffix, e.g. --password-file
+var secretFlags = []string{"password", "token"}
+
+// envName returns a name of environment variable overriding provided flag
+func envName(flag string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+// knownFlags returns names of flags of all commands, config file may contain any of them
+func knownFlags(root *cobra.Command) map[string]bool {
+	known := make(map[string]bool)
+	var walk func(c *cobra.Command)
+	walk = func(c *cobra.Command) {
+		c.Flags().VisitAll(func(f *pflag.Flag) { known[f.Name] = true })
+		c.PersistentFlags().VisitAll(func(f *pflag.Flag) { known[f.Name] = true })
+		for _, sub := range c.Commands() {
+			walk(sub)
+		}
+	}
+	walk(root)
+	for _, name := range []string{"config", "help", "version"} {
+		delete(known, name)
+	}
+
+	return known
+}
+
+// flagValue formats a value from config file as a flag value. Floats are never written
+// in exponent notation, which integer flags would reject
+func flagValue(v interface{}) string {
+	if f, ok := v.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+
+	return fmt.Sprint(v)
+}
+
+// setFlagFromFile sets a flag to a value from config file. Each item of a list is set
+// separately, so list flags receive all of them. Mapping is set as a list of key=value items
+func setFlagFromFile(f *pflag.Flag, value interface{}) error {
+	var items []interface{}
+	switch v := value.(type) {
+	case []interface{}:
+		items = v
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			items = append(items, k+"="+flagValue(v[k]))
+		}
+	default:
+		items = []interface{}{value}
+	}
+	for _, item := range items {
+		if err := f.Value.Set(flagValue(item)); err != nil {
+			return err
+		}
+	}
+	f.Changed = true
+
+	return nil
+}
+
+// readConfigFile reads settings from config file, its keys are names of flags
+func readConfigFile(root *cobra.Command, path string) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	if err := config.DecodeFile(path, &values); err != nil {
+		return nil, err
+	}
+
+	known := knownFlags(root)
+	var unknown []string
+	for k := range values {
+		if !known[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("Unknown settings in config file %s: %s", path, strings.Join(unknown, ", "))
+	}
+
+	return values, nil
+}
+
+// Sources of flag values in order of precedence
+const (
+	fromCommandLine = iota
+	fromEnv
+	fromConfigFile
+	fromDefault
+)
+
+// loadConfig fills flags that are not provided on command line from G2I_* environment
+// variables and then from config file. So the precedence is flag, environment variable,
+// config file and default value. Secrets are then read from files if a secret file is
+// provided by a source of higher precedence than the secret itself
+func loadConfig(cmd *cobra.Command) error {
+	flags := cmd.Flags()
+
+	path, _ := flags.GetString("config")
+	if env, ok := os.LookupEnv(envName("config")); ok && !flags.Changed("config") {
+		path = env
+	}
+	values := make(map[string]interface{})
+	if path != "" {
+		var err error
+		if values, err = readConfigFile(cmd.Root(), path); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	sources := make(map[string]int)
+	flags.VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Name == "config" {
+			return
+		}
+		if f.Changed {
+			sources[f.Name] = fromCommandLine
+			return
+		}
+		if env, ok := os.LookupEnv(envName(f.Name)); ok {
+			if setErr := flags.Set(f.Name, env); setErr != nil {
+				err = fmt.Errorf("Invalid value of %s environment variable: %w", envName(f.Name), setErr)
+			}
+			sources[f.Name] = fromEnv
+			return
+		}
+		if value, ok := values[f.Name]; ok {
+			if setErr := setFlagFromFile(f, value); setErr != nil {
+				err = fmt.Errorf("Invalid value of %s in config file: %w", f.Name, setErr)
+			}
+			sources[f.Name] = fromConfigFile
+		}
+	})
+	if err != nil {
+		return err
+	}
+	source := func(name string) int {
+		if s, ok := sources[name]; ok {
+			return s
+		}
+		return fromDefault
+	}
+
+	for _, name := range secretFlags {
+		secretFile, _ := flags.GetString(name + "-file")
+		// Secret provided directly wins over a file provided the same way
+		if secretFile == "" || source(name) <= source(name+"-file") {
+			continue
+		}
+		secret, err := ioutil.ReadFile(secretFile)
+		if err != nil {
+			return fmt.Errorf("Failed to read %s from file: %w", name, err)
+		}
+		if err := flags.Set(name, strings.TrimRight(string(secret), "\r\n")); err != nil {
+			return err
+		}
+	}
+
+	// Logger is initialized before flags are parsed, so it is restarted if log path is provided
+	if flags.Changed("log") {
+		logPath, _ := flags.GetString("log")
+		if err := l.InitLogger(logPath); err != nil {
+			return fmt.Errorf("Failed to init application logger: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,228 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+// newConfigTestCmd creates a command with a few flags of each kind used by g2i
+func newConfigTestCmd() *cobra.Command {
+	cmd := &cobra.Command{Use: "test"}
+	cmd.Flags().String("config", "", "")
+	cmd.Flags().String("address", "", "")
+	cmd.Flags().Uint("max-batch-size", 5000, "")
+	cmd.Flags().Float64("junit-max-ko", 0, "")
+	cmd.Flags().StringArray("tag", nil, "")
+	cmd.Flags().IntSlice("histogram-buckets", nil, "")
+
+	return cmd
+}
+
+func writeTestConfig(t *testing.T, name, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "g2i-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	for name, content := range map[string]string{
+		"g2i.yaml": `
+address: http://localhost:8086
+max-batch-size: 10000000
+junit-max-ko: 0.05
+tag:
+  build: 12345678
+  env: ci
+histogram-buckets: [100, 250, 1000000]
+`,
+		"g2i.toml": `
+address = "http://localhost:8086"
+max-batch-size = 10000000
+junit-max-ko = 0.05
+histogram-buckets = [100, 250, 1000000]
+
+[tag]
+build = 12345678
+env = "ci"
+`,
+		"g2i.json": `{"address": "http://localhost:8086", "max-batch-size": 10000000, "junit-max-ko": 0.05,
+"tag": {"build": 12345678, "env": "ci"}, "histogram-buckets": [100, 250, 1000000]}`,
+	} {
+		path, cleanup := writeTestConfig(t, name, content)
+		defer cleanup()
+
+		cmd := newConfigTestCmd()
+		if err := cmd.Flags().Set("config", path); err != nil {
+			t.Fatal(err)
+		}
+		if err := loadConfig(cmd); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		address, _ := cmd.Flags().GetString("address")
+		batch, _ := cmd.Flags().GetUint("max-batch-size")
+		ko, _ := cmd.Flags().GetFloat64("junit-max-ko")
+		tags, _ := cmd.Flags().GetStringArray("tag")
+		buckets, _ := cmd.Flags().GetIntSlice("histogram-buckets")
+		if address != "http://localhost:8086" || batch != 10000000 || ko != 0.05 {
+			t.Errorf("%s: unexpected values address=%s max-batch-size=%d junit-max-ko=%v", name, address, batch, ko)
+		}
+		if want := []string{"build=12345678", "env=ci"}; !reflect.DeepEqual(tags, want) {
+			t.Errorf("%s: tags = %q, want %q", name, tags, want)
+		}
+		if want := []int{100, 250, 1000000}; !reflect.DeepEqual(buckets, want) {
+			t.Errorf("%s: histogram buckets = %v, want %v", name, buckets, want)
+		}
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path, cleanup := writeTestConfig(t, "g2i.yaml", "address: http://file:8086\nmax-batch-size: 100\n")
+	defer cleanup()
+
+	os.Setenv("G2I_MAX_BATCH_SIZE", "200")
+	defer os.Unsetenv("G2I_MAX_BATCH_SIZE")
+
+	cmd := newConfigTestCmd()
+	cmd.Flags().Set("config", path)
+	cmd.Flags().Set("address", "http://flag:8086")
+	if err := loadConfig(cmd); err != nil {
+		t.Fatal(err)
+	}
+	address, _ := cmd.Flags().GetString("address")
+	batch, _ := cmd.Flags().GetUint("max-batch-size")
+	if address != "http://flag:8086" || batch != 200 {
+		t.Errorf("unexpected values address=%s max-batch-size=%d", address, batch)
+	}
+}
+
+func TestFlagValue(t *testing.T) {
+	for v, want := range map[interface{}]string{
+		float64(10000000): "10000000",
+		0.05:              "0.05",
+		int64(12345678):   "12345678",
+		true:              "true",
+		"text":            "text",
+	} {
+		if got := flagValue(v); got != want {
+			t.Errorf("flagValue(%#v) = %q, want %q", v, got, want)
+		}
+	}
+}
+
+func TestDetachedChildNotDetachedAgain(t *testing.T) {
+	path, cleanup := writeTestConfig(t, "g2i.yaml", "detached: true\n")
+	defer cleanup()
+
+	os.Setenv("G2I_DETACHED", "true")
+	defer os.Unsetenv("G2I_DETACHED")
+
+	args := detachedArgs([]string{"./results", "-d", "--detached=true", "--config", path, "-t", "id"})
+	if want := []string{"--detached=false", "./results", "--config", path, "-t", "id"}; !reflect.DeepEqual(args, want) {
+		t.Fatalf("detachedArgs = %q, want %q", args, want)
+	}
+
+	cmd := newConfigTestCmd()
+	cmd.Flags().BoolP("detached", "d", false, "")
+	cmd.Flags().StringP("test-id", "t", "", "")
+	if err := cmd.Flags().Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadConfig(cmd); err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := cmd.Flags().GetBool("detached"); d {
+		t.Error("child process is detached again")
+	}
+}
+
+func TestSecretFiles(t *testing.T) {
+	passwordFile, cleanup := writeTestConfig(t, "password", "from-file\n")
+	defer cleanup()
+	tokenFile, cleanup := writeTestConfig(t, "token", "token-from-file\n")
+	defer cleanup()
+
+	for _, tc := range []struct {
+		name   string
+		args   []string
+		env    map[string]string
+		config string
+		want   string
+	}{
+		{"file flag over config", []string{"--password-file", passwordFile}, nil, "password: from-config\n", "from-file"},
+		{"file flag over env", []string{"--password-file", passwordFile}, map[string]string{"G2I_PASSWORD": "from-env"}, "", "from-file"},
+		{"flag over file flag", []string{"--password", "from-flag", "--password-file", passwordFile}, nil, "", "from-flag"},
+		{"file env over config", nil, map[string]string{"G2I_PASSWORD_FILE": passwordFile}, "password: from-config\n", "from-file"},
+		{"env over file config", nil, map[string]string{"G2I_PASSWORD": "from-env"}, "password-file: " + passwordFile + "\n", "from-env"},
+		{"config over file config", nil, nil, "password: from-config\npassword-file: " + passwordFile + "\n", "from-config"},
+		{"file config", nil, nil, "password-file: " + passwordFile + "\n", "from-file"},
+	} {
+		for k, v := range tc.env {
+			os.Setenv(k, v)
+		}
+		args := append([]string{"--token-file", tokenFile}, tc.args...)
+		if tc.config != "" {
+			path, cleanup := writeTestConfig(t, "g2i.yaml", tc.config+"token: token-from-config\n")
+			defer cleanup()
+			args = append(args, "--config", path)
+		}
+
+		cmd := newConfigTestCmd()
+		for _, name := range secretFlags {
+			cmd.Flags().String(name, "", "")
+			cmd.Flags().String(name+"-file", "", "")
+		}
+		if err := cmd.Flags().Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		err := loadConfig(cmd)
+		for k := range tc.env {
+			os.Unsetenv(k)
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+
+		if got, _ := cmd.Flags().GetString("password"); got != tc.want {
+			t.Errorf("%s: password = %q, want %q", tc.name, got, tc.want)
+		}
+		if got, _ := cmd.Flags().GetString("token"); got != "token-from-file" {
+			t.Errorf("%s: token = %q, want value of token file", tc.name, got)
+		}
+	}
+}
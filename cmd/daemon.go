@@ -0,0 +1,131 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/dakaraj/gatling-to-influxdb/parser"
+	"github.com/spf13/cobra"
+)
+
+// printState prints state of g2i process in a human readable form
+func printState(s *parser.ProcessState) {
+	status := "running"
+	switch {
+	case s.Finished:
+		status = "finished"
+	case !parser.ProcessAlive(s.PID):
+		status = "not running"
+	}
+
+	fmt.Printf("Status:\t\t%s\n", status)
+	fmt.Printf("PID:\t\t%d\n", s.PID)
+	fmt.Printf("Watched dir:\t%s\n", s.WatchedDir)
+	fmt.Printf("Log file:\t%s\n", s.LogFile)
+	fmt.Printf("Test ID:\t%s\n", s.TestID)
+	fmt.Printf("Started at:\t%s\n", s.StartedAt.Format(time.RFC3339))
+	fmt.Printf("Updated at:\t%s\n", s.UpdatedAt.Format(time.RFC3339))
+	fmt.Printf("Lines parsed:\t%d\n", s.LinesParsed)
+	fmt.Printf("Lines failed:\t%d\n", s.LinesFailed)
+	for _, o := range s.Outputs {
+		fmt.Printf("Output %s:\tpoints written: %d, points failed: %d, batches spooled: %d\n", o.Name, o.Written, o.Failed, o.Spooled)
+	}
+}
+
+// statusCmd represents the command reporting state of running g2i process
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show status of g2i process",
+	Long: `This command reads the state file written by running
+g2i process and reports its watched directory, test ID,
+lines parsed and points written.`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		stateFile, _ := cmd.Flags().GetString("state-file")
+		s, err := parser.ReadState(stateFile)
+		if os.IsNotExist(err) {
+			return fmt.Errorf("No g2i process found, state file %s does not exist", stateFile)
+		}
+		if err != nil {
+			return err
+		}
+		printState(s)
+
+		return nil
+	},
+}
+
+// stopCmd represents the command stopping running g2i process
+var stopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Stop running g2i process",
+	Long: `This command sends interrupt signal to g2i process found
+in the state file and waits until it sends all remaining
+points and exits. It is not supported on Windows.`,
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		stateFile, _ := cmd.Flags().GetString("state-file")
+		timeout, _ := cmd.Flags().GetUint("timeout")
+		s, err := parser.ReadState(stateFile)
+		if os.IsNotExist(err) {
+			return fmt.Errorf("No g2i process found, state file %s does not exist", stateFile)
+		}
+		if err != nil {
+			return err
+		}
+		if s.Finished || !parser.ProcessAlive(s.PID) {
+			return fmt.Errorf("g2i process with PID %d is not running", s.PID)
+		}
+
+		if err := interruptProcess(s.PID); err != nil {
+			return err
+		}
+		fmt.Printf("Interrupt signal sent to g2i process with PID %d, waiting for it to stop...\n", s.PID)
+
+		deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+		for parser.ProcessAlive(s.PID) {
+			if time.Now().After(deadline) {
+				return errors.New("Timed out waiting for g2i process to stop")
+			}
+			time.Sleep(500 * time.Millisecond)
+		}
+
+		// Final state is written by the process right before it exits
+		if s, err = parser.ReadState(stateFile); err == nil {
+			printState(s)
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	stopCmd.Flags().Uint("timeout", 60, "Time (seconds) to wait for the process to stop")
+	rootCmd.AddCommand(statusCmd, stopCmd)
+}
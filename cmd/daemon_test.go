@@ -0,0 +1,168 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	l "github.com/dakaraj/gatling-to-influxdb/logger"
+	"github.com/dakaraj/gatling-to-influxdb/parser"
+	"github.com/spf13/cobra"
+)
+
+// runDaemonCmd runs provided g2i command and returns its standard output
+func runDaemonCmd(t *testing.T, args ...string) (string, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	out := make(chan string)
+	go func() {
+		data, _ := ioutil.ReadAll(r)
+		out <- string(data)
+	}()
+
+	rootCmd.SetArgs(args)
+	err = rootCmd.ExecuteContext(context.Background())
+	w.Close()
+
+	return <-out, err
+}
+
+// writeDaemonState writes provided state to a state file in provided directory
+func writeDaemonState(t *testing.T, dir string, s parser.ProcessState) string {
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "g2i.state.json")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestStatusReadsWrittenState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "g2i-daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := l.InitLogger(filepath.Join(dir, "g2i.log")); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "g2i.state.json")
+	target := filepath.Join(dir, "target")
+
+	// Stopped process writes its final state without finding any results
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	mainCmd := &cobra.Command{
+		Use: "g2i",
+		Run: func(cmd *cobra.Command, args []string) {
+			parser.RunMain(cmd, target)
+		},
+	}
+	mainCmd.Flags().String("test-id", "", "")
+	mainCmd.Flags().String("state-file", "", "")
+	mainCmd.Flags().Uint("stop-timeout", 1, "")
+	mainCmd.SetArgs([]string{"--test-id", "daemon-test", "--state-file", path})
+	if err := mainCmd.ExecuteContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := runDaemonCmd(t, "status", "--state-file", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Status:\t\tfinished\n",
+		"Watched dir:\t" + target + "\n",
+		"Test ID:\tdaemon-test\n",
+		"Lines parsed:\t0\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("status output does not contain %q:\n%s", want, out)
+		}
+	}
+
+	if _, err := runDaemonCmd(t, "status", "--state-file", filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("status of missing state file succeeded")
+	}
+}
+
+func TestStop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "g2i-daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dead := exec.Command("sh", "-c", "exit 0")
+	if err := dead.Run(); err != nil {
+		t.Fatal(err)
+	}
+	path := writeDaemonState(t, dir, parser.ProcessState{PID: dead.Process.Pid})
+	if _, err := runDaemonCmd(t, "stop", "--state-file", path); err == nil || !strings.Contains(err.Error(), "is not running") {
+		t.Errorf("stop of dead process returned %v", err)
+	}
+
+	running := exec.Command("sleep", "30")
+	if err := running.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		running.Wait()
+		close(exited)
+	}()
+	defer func() {
+		running.Process.Kill()
+		<-exited
+	}()
+	path = writeDaemonState(t, dir, parser.ProcessState{PID: running.Process.Pid, TestID: "daemon-test"})
+	out, err := runDaemonCmd(t, "stop", "--state-file", path, "--timeout", "5")
+	if err != nil {
+		t.Fatalf("stop of running process failed: %v", err)
+	}
+	select {
+	case <-exited:
+	case <-time.After(time.Second):
+		t.Error("process is running after stop")
+	}
+	if !strings.Contains(out, "Test ID:\tdaemon-test\n") {
+		t.Errorf("stop output does not contain final state:\n%s", out)
+	}
+}
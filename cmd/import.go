@@ -0,0 +1,51 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"github.com/dakaraj/gatling-to-influxdb/parser"
+	"github.com/spf13/cobra"
+)
+
+// importCmd represents the command for one-shot import of existing log files
+var importCmd = &cobra.Command{
+	Use: "import [path/to/simulation.log|path/to/results/dir]",
+	Example: `g2i import ./target/gatling/mysimulation-20200731115117240 -t "some-test-id"
+
+Will first check InfluxDB connection.
+Then will parse simulation.log from the provided directory from start to end,
+send all points to InfluxDB and exit with a summary.`,
+	Short: "Import an existing Gatling log file to InfluxDB",
+	Long: `This command parses an already existing Gatling log file
+once at full speed without waiting for new lines to appear.
+Useful for pushing historical test runs to InfluxDB.`,
+	PreRunE: preRunSetup,
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		parser.RunImport(cmd, args[0])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+}
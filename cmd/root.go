@@ -29,6 +29,7 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"github.com/dakaraj/gatling-to-influxdb/influx"
@@ -42,6 +43,21 @@ var (
 	cancel context.CancelFunc
 )
 
+// detachedArgs returns arguments for detached process. Detached flag is filtered out and
+// explicitly disabled, as the child would otherwise enable it again from environment
+// variable or config file and start another process
+func detachedArgs(args []string) []string {
+	newArgs := []string{"--detached=false"}
+	for _, a := range args {
+		if a == "-d" || strings.HasPrefix(a, "-d=") || strings.HasPrefix(a, "--detached") {
+			continue
+		}
+		newArgs = append(newArgs, a)
+	}
+
+	return newArgs
+}
+
 func preRunSetup(cmd *cobra.Command, args []string) error {
 	// // Workaround for a mandatory testid (t) flag
 	// if t, _ := cmd.Flags().GetString("test-id"); t == "" {
@@ -57,18 +73,46 @@ func preRunSetup(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("Failed to establish successful database connection: %w", err)
 	}
 
-	// If detached state is requested, filter out corresponding flags and start new process
-	// returning with same arguments printing its PID. Then close the initial process
-	if d, _ := cmd.Flags().GetBool("detached"); d {
-		newArgs := make([]string, 0, len(os.Args)-2)
-		for _, a := range os.Args[1:] {
-			if a == "-d" || a == "--detached" {
-				continue
-			}
-			newArgs = append(newArgs, a)
+	// Settings of measurements and rules files are checked before detaching,
+	// so invalid values are reported to the user
+	if err := influx.InitMeasurements(cmd); err != nil {
+		return err
+	}
+	slaFile, _ := cmd.Flags().GetString("sla")
+	if err := influx.InitSLA(slaFile); err != nil {
+		return err
+	}
+	nameRules, _ := cmd.Flags().GetString("name-rules")
+	maxNames, _ := cmd.Flags().GetUint("max-names")
+	if err := parser.InitNameRules(nameRules, maxNames); err != nil {
+		return err
+	}
+	errorRules, _ := cmd.Flags().GetString("error-rules")
+	if err := parser.InitErrorRules(errorRules); err != nil {
+		return err
+	}
+	redactRules, _ := cmd.Flags().GetString("redact-rules")
+	maxErrorLength, _ := cmd.Flags().GetUint("max-error-length")
+	if err := parser.InitRedaction(redactRules, maxErrorLength); err != nil {
+		return err
+	}
+
+	// Only one long running process may use the same state file
+	if cmd.Name() != "import" {
+		stateFile, _ := cmd.Flags().GetString("state-file")
+		if err := parser.CheckNotRunning(stateFile); err != nil {
+			return err
 		}
+	}
 
-		command := exec.Command(os.Args[0], newArgs...)
+	// If detached state is requested, start new process with same arguments but not detached
+	// printing its PID. Then close the initial process
+	if d, _ := cmd.Flags().GetBool("detached"); d {
+		command := exec.Command(os.Args[0], detachedArgs(os.Args[1:])...)
+		// Detached process has no standard streams of the parent, it writes to its log file only.
+		// It also runs in its own session, so it is not stopped together with parent terminal
+		command.Stdin, command.Stdout, command.Stderr = nil, nil, nil
+		command.SysProcAttr = detachedProcAttr()
 		if err := command.Start(); err != nil {
 			return fmt.Errorf("Failed to start a detached process: %w", err)
 		}
@@ -77,6 +121,11 @@ func preRunSetup(cmd *cobra.Command, args []string) error {
 		os.Exit(0)
 	}
 
+	// Outputs are created only by the process that does the actual work
+	if err := influx.InitSinks(cmd); err != nil {
+		return fmt.Errorf("Failed to initialize outputs: %w", err)
+	}
+
 	// catcher of SIGINT SIGTERM signals
 	go func() {
 		c := make(chan os.Signal, 1)
@@ -104,6 +153,10 @@ Next will search for simulation.log file to appear and start processing it.`,
 tool logs directly to InfluxDB avoiding unnecessary
 complications of Graphite protocol.`,
 	Version: "v0.1.0",
+	// Settings are loaded before any command runs
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return loadConfig(cmd)
+	},
 	PreRunE: preRunSetup,
 	Args:    cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
@@ -115,7 +168,7 @@ complications of Graphite protocol.`,
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
 	// Initiating logger before any other processes start
-	logPath, _ := rootCmd.Flags().GetString("log")
+	logPath, _ := rootCmd.PersistentFlags().GetString("log")
 	err := l.InitLogger(logPath)
 	if err != nil {
 		log.Fatalf("Failed to init application logger: %v\n", err)
@@ -131,14 +184,45 @@ func init() {
 	rootCmd.Flags().BoolP("help", "h", false, "Display this help for g2i application")
 	rootCmd.Flags().BoolP("version", "v", false, "Display current g2i application version")
 	rootCmd.Flags().BoolP("detached", "d", false, "Run application in background. Returns [PID] on start")
-	rootCmd.Flags().StringP("address", "a", "http://localhost:8086", "HTTP address and port of InfluxDB instance")
-	rootCmd.Flags().StringP("username", "u", "", "Username credential for InfluxDB instance")
-	rootCmd.Flags().StringP("password", "p", "", "Password credential for InfluxDB instance")
-	rootCmd.Flags().StringP("database", "b", "gatling", "Database name in InfluxDB")
-	rootCmd.Flags().StringP("log", "l", "./log/g2i.log", "File path to application log file")
-	rootCmd.Flags().StringP("test-id", "t", "", "Unique test identifier")
 	rootCmd.Flags().UintP("stop-timeout", "s", 60, "Time (seconds) to exit if no new log lines found")
-	rootCmd.Flags().UintP("max-batch-size", "m", 5000, "Max points batch size to sent to InfluxDB")
+
+	// Flags shared with all subcommands
+	rootCmd.PersistentFlags().String("config", "", "Path to YAML, JSON or TOML config file with settings named as flags")
+	rootCmd.PersistentFlags().StringP("address", "a", "http://localhost:8086", "HTTP address and port of InfluxDB instance, or udp://host:port for UDP listener. Empty value disables InfluxDB output")
+	rootCmd.PersistentFlags().StringP("username", "u", "", "Username credential for InfluxDB instance")
+	rootCmd.PersistentFlags().StringP("password", "p", "", "Password credential for InfluxDB instance")
+	rootCmd.PersistentFlags().StringP("database", "b", "gatling", "Database name in InfluxDB")
+	rootCmd.PersistentFlags().String("api-version", "v1", "InfluxDB write API version: v1 for InfluxDB 1.x, v2 for InfluxDB 2.x and 3.x")
+	rootCmd.PersistentFlags().String("org", "", "Organization name in InfluxDB, used with v2 API")
+	rootCmd.PersistentFlags().String("bucket", "", "Bucket name in InfluxDB, used with v2 API")
+	rootCmd.PersistentFlags().String("token", "", "Authorization token for InfluxDB, used with v2 API")
+	rootCmd.PersistentFlags().String("password-file", "", "File to read password credential for InfluxDB instance from")
+	rootCmd.PersistentFlags().String("token-file", "", "File to read authorization token for InfluxDB from")
+	rootCmd.PersistentFlags().StringP("log", "l", "./log/g2i.log", "File path to application log file")
+	rootCmd.PersistentFlags().StringP("test-id", "t", "", "Unique test identifier")
+	rootCmd.PersistentFlags().StringArray("tag", nil, "Extra tag added to all points as key=value, can be repeated")
+	rootCmd.PersistentFlags().StringArray("tag-env", nil, "Extra tag added to all points taken from environment variable as key=VARIABLE or VARIABLE, can be repeated")
+	rootCmd.PersistentFlags().UintP("max-batch-size", "m", 5000, "Max points batch size to sent to InfluxDB")
+	rootCmd.PersistentFlags().Uint("write-concurrency", 1, "Max amount of concurrent write requests to InfluxDB")
+	rootCmd.PersistentFlags().Uint("udp-payload-size", 512, "Max size (bytes) of a single UDP packet sent to InfluxDB")
+	rootCmd.PersistentFlags().StringP("output-file", "o", "", "File path to additionally write points to as line protocol")
+	rootCmd.PersistentFlags().String("state-file", "./log/g2i.state.json", "File path to state file used by 'g2i status' and 'g2i stop' commands")
+	rootCmd.PersistentFlags().String("name-rules", "", "Path to YAML, JSON or TOML file with regex rewrite rules for request and group names")
+	rootCmd.PersistentFlags().Uint("max-names", 0, "Max amount of distinct request names and group names, names beyond it are written as \"other\". 0 means no limit")
+	rootCmd.PersistentFlags().String("error-rules", "", "Path to YAML, JSON or TOML file with regex rules classifying error messages, checked before built-in rules")
+	rootCmd.PersistentFlags().String("redact-rules", "", "Path to YAML, JSON or TOML file with regex rules removing secrets from error messages, applied after built-in ones")
+	rootCmd.PersistentFlags().Uint("max-error-length", 0, "Max length (bytes) of error messages written to points, longer ones are truncated. 0 means no limit")
+	rootCmd.PersistentFlags().String("sla", "", "Path to YAML, JSON or TOML file with SLA rules, application exits with non-zero code if any of them fails")
+	rootCmd.PersistentFlags().String("junit-report", "", "Path to write JUnit XML report to at the end of the test")
+	rootCmd.PersistentFlags().Float64("junit-max-ko", 0, "Maximum ratio (%) of KO executions of a request or group for its JUnit test case to pass")
+	rootCmd.PersistentFlags().Uint("users-interval", 1, "Interval (seconds) to write users activity snapshots for")
+	rootCmd.PersistentFlags().Uint("agg-interval", 0, "Interval (seconds) to write aggregated requests statistics for, 0 disables aggregation")
+	rootCmd.PersistentFlags().IntSlice("histogram-buckets", nil, "Upper bounds (ms) of requests histogram buckets written at each aggregation interval, e.g. 100,250,500,1000")
+	rootCmd.PersistentFlags().IntSlice("histogram-log-buckets", nil, "Logarithmic requests histogram buckets set as min,max,count (ms), e.g. 10,10000,16")
+	rootCmd.PersistentFlags().String("spool-dir", "", "Directory to keep batches that failed to be sent to InfluxDB until it is available again")
+	rootCmd.PersistentFlags().Uint("spool-max-size", 512, "Max size (megabytes) of spooled batches")
+	rootCmd.PersistentFlags().String("prometheus-listen", "", "Address (like :9273) to serve Prometheus metrics at /metrics while test runs")
+	rootCmd.PersistentFlags().Uint("prometheus-linger", 30, "Time (seconds) to keep serving Prometheus metrics after processing is finished, so the final numbers are scraped")
 
 	// set up global context
 	ctx, cancel = context.WithCancel(context.Background())
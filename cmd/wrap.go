@@ -0,0 +1,62 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package cmd
+
+import (
+	"errors"
+
+	"github.com/dakaraj/gatling-to-influxdb/parser"
+	"github.com/spf13/cobra"
+)
+
+// wrapCmd represents the command that runs Gatling as a child process
+var wrapCmd = &cobra.Command{
+	Use: "wrap [path/to/results/dir] -- command [args...]",
+	Example: `g2i wrap ./target/gatling -t "some-test-id" -- sbt "gatling:testOnly simulations.MySimulation"
+
+Will first check InfluxDB connection.
+Then will start provided command streaming its output, search for
+the results directory it creates and process its simulation.log.
+When the command exits and the log is fully read, g2i exits with
+the command exit code or SLA check result if --sla is provided.`,
+	Short: "Run Gatling as a child process and write its log to InfluxDB",
+	Long: `This command starts Gatling (sbt, mvn, gradle or any other command)
+as a child process and parses the log of the simulation it runs
+until the child exits. It replaces detached mode with manual
+stopping of g2i in CI pipelines.`,
+	PreRunE: preRunSetup,
+	Args: func(cmd *cobra.Command, args []string) error {
+		dash := cmd.ArgsLenAtDash()
+		if dash != 1 || len(args) < 2 {
+			return errors.New("Expected results directory followed by -- and a command to run")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		parser.RunWrap(cmd, args[0], args[1:])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(wrapCmd)
+}
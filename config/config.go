@@ -0,0 +1,129 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// DecodeFile reads a JSON, YAML or TOML (by .toml extension) file and stores its content
+// in the value pointed to by v. Field names are matched using json struct tags in all cases
+func DecodeFile(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Failed to read %s: %w", path, err)
+	}
+
+	decode := Decode
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		decode = DecodeTOML
+	}
+	if err := decode(data, v); err != nil {
+		return fmt.Errorf("Failed to decode %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// DecodeFileStrict is like DecodeFile, but reports keys that do not match
+// any field of a struct as an error
+func DecodeFileStrict(path string, v interface{}) error {
+	var doc interface{}
+	if err := DecodeFile(path, &doc); err != nil {
+		return err
+	}
+
+	j, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	d := json.NewDecoder(bytes.NewReader(j))
+	d.UseNumber()
+	d.DisallowUnknownFields()
+	if err := d.Decode(v); err != nil {
+		return fmt.Errorf("Failed to decode %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// Decode stores JSON or YAML data in the value pointed to by v
+func Decode(data []byte, v interface{}) error {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		return unmarshalJSON(trimmed, v)
+	}
+
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+
+	return convert(stringKeys(doc), v)
+}
+
+// stringKeys converts mappings decoded from YAML, which may have keys of any type,
+// to mappings with string keys, so they can be encoded to JSON
+func stringKeys(doc interface{}) interface{} {
+	switch d := doc.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(d))
+		for k, v := range d {
+			m[fmt.Sprint(k)] = stringKeys(v)
+		}
+		return m
+	case []interface{}:
+		for i := range d {
+			d[i] = stringKeys(d[i])
+		}
+	}
+
+	return doc
+}
+
+// convert stores a document of JSON compatible values in the value pointed to by v,
+// so JSON decoder does type conversions
+func convert(doc interface{}, v interface{}) error {
+	j, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	return unmarshalJSON(j, v)
+}
+
+// unmarshalJSON decodes JSON keeping numbers stored in interface values as json.Number,
+// so big integers are not turned into floats printed in exponent notation
+func unmarshalJSON(data []byte, v interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+
+	return d.Decode(v)
+}
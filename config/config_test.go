@@ -0,0 +1,314 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testRule struct {
+	Match   string `json:"match"`
+	Replace string `json:"replace"`
+	Class   string `json:"class"`
+}
+
+type testRules struct {
+	Rules []testRule `json:"rules"`
+}
+
+func TestDecodeYAMLRules(t *testing.T) {
+	doc := `# name rules
+---
+rules:
+  - match: '/orders/\d+'
+    replace: '/orders/:id'
+  - match: "a \"quoted\" # not a comment"   # a comment
+    replace: ''
+  -
+    match: 'it''s'
+    class: plain value
+rules_at_key_indent_are_ignored_by_struct:
+- 1
+- two
+`
+	var got testRules
+	if err := Decode([]byte(doc), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := testRules{Rules: []testRule{
+		{Match: `/orders/\d+`, Replace: "/orders/:id"},
+		{Match: `a "quoted" # not a comment`},
+		{Match: "it's", Class: "plain value"},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestDecodeYAMLScalars(t *testing.T) {
+	doc := `
+address: http://localhost:8086
+int: 42
+big: 10000000
+negative: -7
+float: 0.5
+enabled: true
+disabled: false
+empty:
+tilde: ~
+null_value: null
+emptyMap: {}
+list: [100, "a, b", 'c']
+emptyList: []
+nested:
+  key: value
+  deeper:
+    - x
+    - w: 1
+      z: 2
+`
+	var got map[string]interface{}
+	if err := Decode([]byte(doc), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"address":    "http://localhost:8086",
+		"big":        json.Number("10000000"),
+		"int":        json.Number("42"),
+		"negative":   json.Number("-7"),
+		"float":      json.Number("0.5"),
+		"enabled":    true,
+		"disabled":   false,
+		"empty":      nil,
+		"tilde":      nil,
+		"null_value": nil,
+		"emptyMap":   map[string]interface{}{},
+		"list":       []interface{}{json.Number("100"), "a, b", "c"},
+		"emptyList":  []interface{}{},
+		"nested": map[string]interface{}{
+			"key": "value",
+			"deeper": []interface{}{
+				"x",
+				map[string]interface{}{"w": json.Number("1"), "z": json.Number("2")},
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v\nwant %#v", got, want)
+	}
+}
+
+func TestDecodeYAMLFlowAndAnchors(t *testing.T) {
+	doc := `
+defaults: &defaults {match: 'a', class: flow}
+rules:
+  - *defaults
+  - <<: *defaults
+    match: b
+  - match: |
+      multi
+      line
+`
+	var got testRules
+	if err := Decode([]byte(doc), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := testRules{Rules: []testRule{
+		{Match: "a", Class: "flow"},
+		{Match: "b", Class: "flow"},
+		{Match: "multi\nline\n"},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestDecodeTypedFields(t *testing.T) {
+	var got struct {
+		Buckets []int   `json:"buckets"`
+		Size    uint    `json:"size"`
+		Ratio   float64 `json:"ratio"`
+		Tags    []string
+	}
+	doc := "buckets: [100, 250]\nsize: 512\nratio: 0.05\nTags:\n  - a=b\n  - c=d\n"
+	if err := Decode([]byte(doc), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Buckets, []int{100, 250}) || got.Size != 512 || got.Ratio != 0.05 ||
+		!reflect.DeepEqual(got.Tags, []string{"a=b", "c=d"}) {
+		t.Errorf("unexpected result %+v", got)
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	var got testRules
+	doc := ` {"rules": [{"match": "a", "replace": "b"}]}`
+	if err := Decode([]byte(doc), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, testRules{Rules: []testRule{{Match: "a", Replace: "b"}}}) {
+		t.Errorf("unexpected result %+v", got)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for name, doc := range map[string]string{
+		"tab indentation":    "a:\n\t- b\n",
+		"missing colon":      "a: 1\nb\n",
+		"bad indentation":    "a:\n    b: 1\n  c: 2\n",
+		"unterminated quote": "a: 'b\n",
+		"unterminated list":  "a: [1, 2\n",
+		"invalid JSON":       "{\"a\": }",
+	} {
+		var v map[string]interface{}
+		if err := Decode([]byte(doc), &v); err == nil {
+			t.Errorf("%s: expected error, got %v", name, v)
+		}
+	}
+}
+
+func TestDecodeFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "g2i-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "rules.yaml")
+	if err := ioutil.WriteFile(path, []byte("rules:\n  - match: a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var got testRules
+	if err := DecodeFile(path, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Rules) != 1 || got.Rules[0].Match != "a" {
+		t.Errorf("unexpected result %+v", got)
+	}
+
+	if err := DecodeFile(filepath.Join(dir, "missing.yaml"), &got); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestDecodeTOML(t *testing.T) {
+	doc := `
+# name rules
+[[rules]]
+match = '/orders/\d+'
+replace = "/orders/:id"
+
+[[rules]]
+match = "it's"
+class = "plain value"
+`
+	var got testRules
+	if err := DecodeTOML([]byte(doc), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := testRules{Rules: []testRule{
+		{Match: `/orders/\d+`, Replace: "/orders/:id"},
+		{Match: "it's", Class: "plain value"},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	var settings map[string]interface{}
+	doc = "max-batch-size = 10000000\nratio = 0.5\nbuckets = [100, 250]\n[tag]\nbuild = 12345678\n"
+	if err := DecodeTOML([]byte(doc), &settings); err != nil {
+		t.Fatal(err)
+	}
+	wantSettings := map[string]interface{}{
+		"max-batch-size": json.Number("10000000"),
+		"ratio":          json.Number("0.5"),
+		"buckets":        []interface{}{json.Number("100"), json.Number("250")},
+		"tag":            map[string]interface{}{"build": json.Number("12345678")},
+	}
+	if !reflect.DeepEqual(settings, wantSettings) {
+		t.Errorf("got %#v\nwant %#v", settings, wantSettings)
+	}
+
+	if err := DecodeTOML([]byte("a = \n"), &settings); err == nil {
+		t.Error("expected error for invalid TOML")
+	}
+}
+
+func TestDecodeFileByExtension(t *testing.T) {
+	dir, err := ioutil.TempDir("", "g2i-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, content := range map[string]string{
+		"rules.yaml": "rules:\n  - match: a\n",
+		"rules.json": `{"rules": [{"match": "a"}]}`,
+		"rules.toml": "[[rules]]\nmatch = \"a\"\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		var got testRules
+		if err := DecodeFile(path, &got); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(got.Rules) != 1 || got.Rules[0].Match != "a" {
+			t.Errorf("%s: unexpected result %+v", name, got)
+		}
+	}
+}
+
+func TestDecodeFileStrict(t *testing.T) {
+	dir, err := ioutil.TempDir("", "g2i-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "rules.yaml")
+	if err := ioutil.WriteFile(path, []byte("rules:\n  - match: a\n    replace: b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var got testRules
+	if err := DecodeFileStrict(path, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Rules) != 1 || got.Rules[0].Replace != "b" {
+		t.Errorf("unexpected result %+v", got)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("rules:\n  - match: a\n    replcae: b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := DecodeFileStrict(path, &got); err == nil || !strings.Contains(err.Error(), "replcae") {
+		t.Errorf("expected unknown field error, got %v", err)
+	}
+}
@@ -3,6 +3,9 @@ module github.com/dakaraj/gatling-to-influxdb
 go 1.13
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/influxdata/influxdb1-client v0.0.0-20200515024757-02f0bf5dbca3
 	github.com/spf13/cobra v1.0.0
+	github.com/spf13/pflag v1.0.3
+	gopkg.in/yaml.v2 v2.4.0
 )
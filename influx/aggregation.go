@@ -0,0 +1,405 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package influx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	l "github.com/dakaraj/gatling-to-influxdb/logger"
+	infc "github.com/influxdata/influxdb1-client/v2"
+)
+
+type requestLineData struct {
+	timestamp time.Time
+	name      string
+	groups    string
+	result    string
+	duration  int
+	isGroup   bool
+}
+
+// aggKey identifies a series of aggregated requests data
+type aggKey struct {
+	name   string
+	groups string
+	result string
+}
+
+// summaryKey identifies a request or a group in the whole test summary
+type summaryKey struct {
+	name    string
+	groups  string
+	isGroup bool
+}
+
+// summaryData holds durations of a request or a group over the whole test
+type summaryData struct {
+	durations *histogram
+	koCount   int
+}
+
+var (
+	// aggInterval is a length of aggregation interval, zero disables aggregation
+	aggInterval time.Duration
+
+	// rc is a channel for requestLineData aggregation
+	rc = make(chan requestLineData, 1000)
+
+	// histogramBuckets holds sorted upper bounds (ms) of requests_histogram buckets,
+	// empty slice disables histogram
+	histogramBuckets []int
+
+	// summaries is only accessed by requestsAggregator until it is stopped
+	summaries = make(map[summaryKey]*summaryData)
+	// totalSummary holds durations of all requests over the whole test
+	totalSummary = &summaryData{durations: newHistogram()}
+)
+
+// initHistogramBuckets sets histogram bucket bounds from explicit list or from
+// logarithmic bucket spec of minimum, maximum and count of buckets
+func initHistogramBuckets(buckets, logBuckets []int) error {
+	if len(buckets) > 0 && len(logBuckets) > 0 {
+		return errors.New("Only one of histogram buckets and logarithmic histogram buckets can be set")
+	}
+
+	if len(logBuckets) > 0 {
+		if len(logBuckets) != 3 {
+			return errors.New("Logarithmic histogram buckets must be set as min,max,count")
+		}
+		min, max, count := logBuckets[0], logBuckets[1], logBuckets[2]
+		if min <= 0 || max <= min || count < 2 {
+			return fmt.Errorf("Invalid logarithmic histogram buckets %d,%d,%d: expected 0 < min < max and count > 1", min, max, count)
+		}
+		factor := math.Pow(float64(max)/float64(min), 1/float64(count-1))
+		for i := 0; i < count; i++ {
+			buckets = append(buckets, int(math.Round(float64(min)*math.Pow(factor, float64(i)))))
+		}
+	}
+
+	sort.Ints(buckets)
+	for _, b := range buckets {
+		// Rounding of small logarithmic buckets may produce duplicates
+		if len(histogramBuckets) > 0 && histogramBuckets[len(histogramBuckets)-1] == b {
+			continue
+		}
+		if b < 0 {
+			return fmt.Errorf("Invalid histogram bucket %d: must not be negative", b)
+		}
+		histogramBuckets = append(histogramBuckets, b)
+	}
+
+	if len(histogramBuckets) > 0 && aggInterval == 0 {
+		return errors.New("Histogram buckets require aggregation interval to be set")
+	}
+
+	return nil
+}
+
+// SendRequestData takes request data and adds it to the aggregation
+func SendRequestData(timestamp time.Time, name, groups, result string, duration int) {
+	rc <- requestLineData{timestamp, name, groups, result, duration, false}
+}
+
+// SendGroupData takes group data and adds it to the whole test summary
+func SendGroupData(timestamp time.Time, name, result string, duration int) {
+	rc <- requestLineData{timestamp, name, "", result, duration, true}
+}
+
+// addToSummary records request or group duration over the whole test
+func addToSummary(r requestLineData) {
+	k := summaryKey{name: r.name, groups: r.groups, isGroup: r.isGroup}
+	s, ok := summaries[k]
+	if !ok {
+		s = &summaryData{durations: newHistogram()}
+		summaries[k] = s
+	}
+	s.durations.record(int64(r.duration))
+	if r.result == "KO" {
+		s.koCount++
+	}
+
+	if r.isGroup {
+		return
+	}
+	totalSummary.durations.record(int64(r.duration))
+	if r.result == "KO" {
+		totalSummary.koCount++
+	}
+}
+
+// sendSummaryPoints sends whole test statistics for each request and group,
+// should be called only after requestsAggregator is stopped
+func sendSummaryPoints(timestamp time.Time) {
+	for k, s := range summaries {
+		recordType := "request"
+		if k.isGroup {
+			recordType = "group"
+		}
+		h := s.durations
+
+		point, err := NewPoint(
+			"summary",
+			map[string]string{
+				"name":       k.name,
+				"groups":     k.groups,
+				"type":       recordType,
+				"simulation": info.simulationName,
+				"testId":     info.testID,
+				"nodeName":   info.nodeName,
+			},
+			map[string]interface{}{
+				"count":   h.count,
+				"koCount": s.koCount,
+				"min":     h.min,
+				"max":     h.max,
+				"mean":    h.mean(),
+				"p50":     h.valueAt(50),
+				"p75":     h.valueAt(75),
+				"p90":     h.valueAt(90),
+				"p95":     h.valueAt(95),
+				"p99":     h.valueAt(99),
+				"p999":    h.valueAt(99.9),
+			},
+			timestamp,
+		)
+		if err != nil {
+			l.Errorf("Error creating new point with summary data: %v\n", err)
+			continue
+		}
+
+		pc <- point
+	}
+}
+
+// percentile returns a value at provided percentile of sorted values using nearest-rank method
+func percentile(sorted []int, p float64) int {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
+
+// aggregatedPoints creates points with statistics of requests durations for one interval
+func aggregatedPoints(intervalStart time.Time, data map[aggKey][]int) ([]*infc.Point, error) {
+	points := make([]*infc.Point, 0, len(data))
+	for k, durations := range data {
+		sort.Ints(durations)
+
+		var sum int
+		for _, d := range durations {
+			sum += d
+		}
+		var errorCount int
+		if k.result == "KO" {
+			errorCount = len(durations)
+		}
+
+		point, err := NewPoint(
+			"requests_agg",
+			map[string]string{
+				"name":       k.name,
+				"groups":     k.groups,
+				"result":     k.result,
+				"simulation": info.simulationName,
+				"testId":     info.testID,
+				"nodeName":   info.nodeName,
+			},
+			map[string]interface{}{
+				"count":      len(durations),
+				"errorCount": errorCount,
+				"min":        durations[0],
+				"max":        durations[len(durations)-1],
+				"mean":       float64(sum) / float64(len(durations)),
+				"p50":        percentile(durations, 50),
+				"p75":        percentile(durations, 75),
+				"p95":        percentile(durations, 95),
+				"p99":        percentile(durations, 99),
+			},
+			intervalStart,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating new point with aggregated requests data: %w", err)
+		}
+
+		points = append(points, point)
+	}
+
+	if len(histogramBuckets) == 0 {
+		return points, nil
+	}
+
+	histogramPoints, err := histogramPointsFor(intervalStart, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(points, histogramPoints...), nil
+}
+
+// histogramPointsFor creates points with count of requests durations for each
+// histogram bucket of each request regardless of its result
+func histogramPointsFor(intervalStart time.Time, data map[aggKey][]int) ([]*infc.Point, error) {
+	type histogramKey struct {
+		name   string
+		groups string
+	}
+
+	// Last element counts durations above the biggest bound
+	counts := make(map[histogramKey][]int)
+	for k, durations := range data {
+		hk := histogramKey{name: k.name, groups: k.groups}
+		c, ok := counts[hk]
+		if !ok {
+			c = make([]int, len(histogramBuckets)+1)
+			counts[hk] = c
+		}
+		for _, d := range durations {
+			c[sort.SearchInts(histogramBuckets, d)]++
+		}
+	}
+
+	points := make([]*infc.Point, 0, len(counts)*(len(histogramBuckets)+1))
+	for hk, c := range counts {
+		var cumulative int
+		for i, count := range c {
+			le := "+Inf"
+			if i < len(histogramBuckets) {
+				le = strconv.Itoa(histogramBuckets[i])
+			}
+			cumulative += count
+
+			point, err := NewPoint(
+				"requests_histogram",
+				map[string]string{
+					"name":       hk.name,
+					"groups":     hk.groups,
+					"le":         le,
+					"simulation": info.simulationName,
+					"testId":     info.testID,
+					"nodeName":   info.nodeName,
+				},
+				map[string]interface{}{
+					"count":      count,
+					"cumulative": cumulative,
+				},
+				intervalStart,
+			)
+			if err != nil {
+				return nil, fmt.Errorf("Error creating new point with requests histogram data: %w", err)
+			}
+
+			points = append(points, point)
+		}
+	}
+
+	return points, nil
+}
+
+// requestsAggregator collects requests and groups durations for the whole test summary.
+// If aggregation interval is set, it also collects requests durations into intervals aligned
+// by log timestamps and sends statistics for each of them. An interval is considered complete
+// when requests of the interval after next one appear, so slightly out of order lines are not lost
+func requestsAggregator(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	intervals := make(map[time.Time]map[aggKey][]int)
+	var latest, flushedBefore time.Time
+
+	// flush sends data of all intervals started before provided time in chronological order
+	flush := func(before time.Time) {
+		starts := make([]time.Time, 0, len(intervals))
+		for start := range intervals {
+			if start.Before(before) {
+				starts = append(starts, start)
+			}
+		}
+		sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+
+		for _, start := range starts {
+			points, err := aggregatedPoints(start, intervals[start])
+			if err != nil {
+				l.Errorf("Failed to send aggregated data: %v", err)
+			}
+			for _, p := range points {
+				pc <- p
+			}
+			delete(intervals, start)
+		}
+		if before.After(flushedBefore) {
+			flushedBefore = before
+		}
+	}
+
+	process := func(r requestLineData) {
+		addToSummary(r)
+		if r.isGroup || aggInterval == 0 {
+			return
+		}
+
+		start := r.timestamp.Truncate(aggInterval)
+		// Data of already sent interval would overwrite it, so it is skipped
+		if start.Before(flushedBefore) {
+			l.Debugf("Skipping aggregation of request %s finished at %v, its interval is already sent\n", r.name, r.timestamp)
+			return
+		}
+
+		data, ok := intervals[start]
+		if !ok {
+			data = make(map[aggKey][]int)
+			intervals[start] = data
+		}
+		k := aggKey{name: r.name, groups: r.groups, result: r.result}
+		data[k] = append(data[k], r.duration)
+
+		if start.After(latest) {
+			latest = start
+			flush(latest.Add(-aggInterval))
+		}
+	}
+
+	for {
+		select {
+		// If an external cancellation signal is received
+		case <-ctx.Done():
+			// Process request data that is still buffered in the channel
+			for len(rc) > 0 {
+				process(<-rc)
+			}
+			// Send all incomplete intervals
+			flush(latest.Add(aggInterval))
+			return
+		// On each new request data
+		case r := <-rc:
+			process(r)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package influx
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	infc "github.com/influxdata/influxdb1-client/v2"
+)
+
+// aggStart is aligned to aggregation intervals used by tests
+var aggStart = time.Unix(1596196270, 0)
+
+// receivePoints reads n points sent by aggregator
+func receivePoints(t *testing.T, n int) []*infc.Point {
+	t.Helper()
+	points := make([]*infc.Point, 0, n)
+	for len(points) < n {
+		select {
+		case p := <-pc:
+			points = append(points, p)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected %d points, got %d", n, len(points))
+		}
+	}
+
+	return points
+}
+
+// drainPoints returns points already sent to the collector channel
+func drainPoints() []*infc.Point {
+	var points []*infc.Point
+	for len(pc) > 0 {
+		points = append(points, <-pc)
+	}
+
+	return points
+}
+
+// aggCounts returns requests count of each interval of requests_agg points
+func aggCounts(t *testing.T, points []*infc.Point) map[time.Time]interface{} {
+	t.Helper()
+	counts := make(map[time.Time]interface{})
+	for _, p := range points {
+		if p.Name() != "requests_agg" {
+			t.Errorf("unexpected measurement %s", p.Name())
+			continue
+		}
+		if _, ok := counts[p.Time()]; ok {
+			t.Errorf("interval %v is sent twice", p.Time())
+		}
+		fields, _ := p.Fields()
+		counts[p.Time()] = fields["count"]
+	}
+
+	return counts
+}
+
+func TestRequestsAggregator(t *testing.T) {
+	defer func(v time.Duration) { aggInterval = v }(aggInterval)
+	aggInterval = 10 * time.Second
+	resetSLA()
+	defer resetSLA()
+	drainPoints()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go requestsAggregator(ctx, wg)
+
+	at := func(s int) time.Time { return aggStart.Add(time.Duration(s) * time.Second) }
+	SendRequestData(at(1), "home", "", "OK", 100)
+	SendRequestData(at(12), "home", "", "OK", 200)
+	// Out of order line of the first interval is aggregated until the interval after next appears
+	SendRequestData(at(5), "home", "", "OK", 300)
+	SendGroupData(at(6), "login", "OK", 400)
+	SendRequestData(at(21), "home", "", "OK", 500)
+
+	points := receivePoints(t, 1)
+	if p := points[0]; !p.Time().Equal(at(0)) {
+		t.Fatalf("first interval is sent at %v, want %v", p.Time(), at(0))
+	}
+	fields, _ := points[0].Fields()
+	if fields["count"] != int64(2) || fields["min"] != int64(100) || fields["max"] != int64(300) || fields["mean"] != 200.0 {
+		t.Errorf("unexpected first interval fields %v", fields)
+	}
+
+	// Data of already sent interval is skipped, the rest is sent on stop
+	SendRequestData(at(3), "home", "", "OK", 600)
+	cancel()
+	wg.Wait()
+
+	counts := aggCounts(t, drainPoints())
+	if want := map[time.Time]interface{}{at(10): int64(1), at(20): int64(1)}; len(counts) != len(want) ||
+		counts[at(10)] != want[at(10)] || counts[at(20)] != want[at(20)] {
+		t.Errorf("final flush sent %v, want %v", counts, want)
+	}
+
+	if s := summaries[summaryKey{name: "home"}]; s == nil || s.durations.count != 5 {
+		t.Errorf("unexpected requests summary %+v", s)
+	}
+	if s := summaries[summaryKey{name: "login", isGroup: true}]; s == nil || s.durations.count != 1 {
+		t.Errorf("unexpected group summary %+v", s)
+	}
+}
+
+func TestRequestsAggregatorWithoutInterval(t *testing.T) {
+	resetSLA()
+	defer resetSLA()
+	drainPoints()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go requestsAggregator(ctx, wg)
+
+	SendRequestData(aggStart, "home", "", "KO", 100)
+	cancel()
+	wg.Wait()
+
+	if points := drainPoints(); len(points) != 0 {
+		t.Errorf("no points are expected without aggregation interval, got %d", len(points))
+	}
+	if totalSummary.durations.count != 1 || totalSummary.koCount != 1 {
+		t.Errorf("unexpected total summary %+v", totalSummary)
+	}
+}
+
+func TestHistogramPoints(t *testing.T) {
+	defer func(v []int) { histogramBuckets = v }(histogramBuckets)
+	histogramBuckets = []int{100, 250, 1000}
+
+	data := map[aggKey][]int{
+		{name: "home", result: "OK"}: {50, 100, 101, 250, 1000},
+		{name: "home", result: "KO"}: {999, 5000},
+	}
+	points, err := histogramPointsFor(aggStart, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Bucket counts durations up to and including its bound, requests of all results are counted together
+	want := map[string][2]int64{
+		"100":  {2, 2},
+		"250":  {2, 4},
+		"1000": {2, 6},
+		"+Inf": {1, 7},
+	}
+	if len(points) != len(want) {
+		t.Fatalf("expected %d points, got %d", len(want), len(points))
+	}
+	for _, p := range points {
+		le := p.Tags()["le"]
+		fields, _ := p.Fields()
+		got := [2]int64{fields["count"].(int64), fields["cumulative"].(int64)}
+		if p.Name() != "requests_histogram" || got != want[le] {
+			t.Errorf("%s le=%s: count and cumulative %v, want %v", p.Name(), le, got, want[le])
+		}
+	}
+}
+
+func TestInitHistogramBuckets(t *testing.T) {
+	defer func(v time.Duration, b []int) { aggInterval, histogramBuckets = v, b }(aggInterval, histogramBuckets)
+	aggInterval = 10 * time.Second
+
+	histogramBuckets = nil
+	if err := initHistogramBuckets([]int{250, 100, 100}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(histogramBuckets, []int{100, 250}) {
+		t.Errorf("buckets are not sorted and deduplicated: %v", histogramBuckets)
+	}
+
+	histogramBuckets = nil
+	if err := initHistogramBuckets(nil, []int{1, 1000, 4}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1, 10, 100, 1000}; !reflect.DeepEqual(histogramBuckets, want) {
+		t.Errorf("logarithmic buckets %v, want %v", histogramBuckets, want)
+	}
+
+	for _, c := range [][2][]int{
+		{{100}, {1, 1000, 4}},
+		{nil, {1, 1000}},
+		{nil, {1000, 1, 4}},
+		{{-1}, nil},
+	} {
+		histogramBuckets = nil
+		if err := initHistogramBuckets(c[0], c[1]); err == nil {
+			t.Errorf("expected error for buckets %v and logarithmic buckets %v", c[0], c[1])
+		}
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package influx
+
+import (
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// histogramSubBuckets is a number of linear sub buckets inside each power of two range,
+// it keeps values with precision of 3 significant digits, like HDR histogram does
+const histogramSubBuckets = 2048
+
+// histogram is a sparse HDR histogram of durations in milliseconds. Values below
+// histogramSubBuckets are recorded exactly, bigger ones with relative error below 0.1%
+type histogram struct {
+	counts map[int64]int64
+	// sorted are lowest values of buckets in ascending order, built on demand
+	// and reset when a new bucket is added
+	sorted []int64
+	count  int64
+	sum    int64
+	min    int64
+	max    int64
+}
+
+func newHistogram() *histogram {
+	return &histogram{
+		counts: make(map[int64]int64),
+		min:    math.MaxInt64,
+	}
+}
+
+// bucketShift returns a number of low bits of a value that are not significant
+func bucketShift(v int64) uint {
+	n := bits.Len64(uint64(v))
+	if n <= bits.Len64(histogramSubBuckets-1) {
+		return 0
+	}
+
+	return uint(n - bits.Len64(histogramSubBuckets-1))
+}
+
+// record adds a value to histogram, negative values are counted as zero
+func (h *histogram) record(v int64) {
+	if v < 0 {
+		v = 0
+	}
+	shift := bucketShift(v)
+	bucket := v >> shift << shift
+	if _, ok := h.counts[bucket]; !ok {
+		h.sorted = nil
+	}
+	h.counts[bucket]++
+	h.count++
+	h.sum += v
+	if v < h.min {
+		h.min = v
+	}
+	if v > h.max {
+		h.max = v
+	}
+}
+
+func (h *histogram) mean() float64 {
+	if h.count == 0 {
+		return 0
+	}
+
+	return float64(h.sum) / float64(h.count)
+}
+
+// valueAt returns the highest value equivalent to the one at provided percentile
+func (h *histogram) valueAt(p float64) int64 {
+	if h.count == 0 {
+		return 0
+	}
+
+	if h.sorted == nil {
+		h.sorted = make([]int64, 0, len(h.counts))
+		for v := range h.counts {
+			h.sorted = append(h.sorted, v)
+		}
+		sort.Slice(h.sorted, func(i, j int) bool { return h.sorted[i] < h.sorted[j] })
+	}
+
+	rank := int64(math.Ceil(p / 100 * float64(h.count)))
+	if rank < 1 {
+		rank = 1
+	}
+	var total int64
+	for _, v := range h.sorted {
+		total += h.counts[v]
+		if total >= rank {
+			highest := v + 1<<bucketShift(v) - 1
+			if highest > h.max {
+				return h.max
+			}
+			return highest
+		}
+	}
+
+	return h.max
+}
@@ -0,0 +1,96 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package influx
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// exactPercentile returns a value at percentile using nearest rank method
+func exactPercentile(sorted []int64, p float64) int64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
+
+func TestHistogramPercentileAccuracy(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for name, gen := range map[string]func() int64{
+		"small":       func() int64 { return r.Int63n(histogramSubBuckets) },
+		"uniform":     func() int64 { return r.Int63n(60000) },
+		"exponential": func() int64 { return int64(r.ExpFloat64() * 300) },
+		"long tail":   func() int64 { return int64(math.Exp(r.Float64() * 14)) },
+	} {
+		h := newHistogram()
+		values := make([]int64, 0, 100000)
+		for i := 0; i < cap(values); i++ {
+			v := gen()
+			values = append(values, v)
+			h.record(v)
+		}
+		sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+		for _, p := range []float64{0, 1, 50, 75, 90, 95, 99, 99.9, 100} {
+			want := exactPercentile(values, p)
+			got := h.valueAt(p)
+			// Values are kept with 3 significant digits, so only bigger ones may differ
+			if diff := math.Abs(float64(got - want)); diff > float64(want)/1000 {
+				t.Errorf("%s: p%v = %d, exact %d", name, p, got, want)
+			}
+		}
+		if h.min != values[0] || h.max != values[len(values)-1] {
+			t.Errorf("%s: min %d max %d, exact %d and %d", name, h.min, h.max, values[0], values[len(values)-1])
+		}
+	}
+}
+
+func TestHistogramExactValues(t *testing.T) {
+	h := newHistogram()
+	if h.valueAt(50) != 0 || h.mean() != 0 {
+		t.Error("empty histogram must report zeros")
+	}
+	for _, v := range []int64{-5, 10, 20, 30, 40} {
+		h.record(v)
+	}
+	if got := h.valueAt(50); got != 20 {
+		t.Errorf("p50 = %d, want 20", got)
+	}
+	if got := h.mean(); got != 20 {
+		t.Errorf("mean = %v, want 20", got)
+	}
+
+	// Recording a value after a query must be reflected in the next one
+	h.record(1000000)
+	if got := h.valueAt(100); got != 1000000 {
+		t.Errorf("p100 = %d, want 1000000", got)
+	}
+	if got := h.valueAt(50); got != 20 {
+		t.Errorf("p50 = %d, want 20", got)
+	}
+}
@@ -24,8 +24,11 @@ package influx
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"path/filepath"
 	"runtime"
+	"strings"
 	"sync"
 	"time"
 
@@ -41,6 +44,7 @@ type testInfo struct {
 	simulationName string
 	description    string
 	nodeName       string
+	gatlingVersion string
 	testStartTime  time.Time
 }
 
@@ -50,10 +54,15 @@ type userLineData struct {
 	status    string
 }
 
+// allScenarios is a scenario tag value of users totals for all scenarios
+const allScenarios = "all"
+
 type users struct {
-    active  int
-    started int
-    ended   int
+	active  int
+	started int
+	ended   int
+	// started value at the moment of previous send, used to calculate arrival rate
+	reportedStarted int
 }
 
 var (
@@ -63,69 +72,76 @@ var (
 	lastPoint time.Time
 	maxPoints uint
 
+	// testInfoReady is closed when test information of current run is filled from RUN line
+	testInfoReady = make(chan struct{})
+
 	// pc is a channel to send all point from parser to
 	pc = make(chan *infc.Point, 1000)
 	// uc is a channel for userLineData processing
 	uc = make(chan userLineData, 1000)
+	// usersInterval is a length of time range users activity is sent for
+	usersInterval = time.Second
+	// allScenarioWarning is shown once if a scenario has the name of users totals
+	allScenarioWarning sync.Once
 
 	// TODO: parameterize later
 	writeDataTimeout = 1
+
+	// sinks are workers of all configured outputs that points are sent to
+	sinks []*sinkWorker
 )
 
 // InitTestInfo collect basic test information to be used by Influx client
-func InitTestInfo(testID, simulationName, description, nodeName string, testStartTime time.Time) {
+func InitTestInfo(testID, simulationName, description, nodeName, gatlingVersion string, testStartTime time.Time) {
 	info = testInfo{
 		testID:         testID,
 		simulationName: simulationName,
 		description:    description,
 		nodeName:       nodeName,
+		gatlingVersion: gatlingVersion,
 		testStartTime:  testStartTime,
 	}
+	// Log has a single RUN line, but a broken one may repeat it
+	select {
+	case <-testInfoReady:
+	default:
+		close(testInfoReady)
+	}
+}
+
+// ResetTestInfo clears test information of a previous run, so points processor waits
+// for the new one. Must be called before parser and points processor are started
+func ResetTestInfo() {
+	info = testInfo{}
+	testInfoReady = make(chan struct{})
 }
 
 // NewPoint is mostly an alias fo standard NewPoint function from influx package,
-// except timestamp is required
+// except timestamp is required and static tags are added to provided tags map
 func NewPoint(name string, tags map[string]string, fields map[string]interface{}, t time.Time) (*infc.Point, error) {
-	return infc.NewPoint(name, tags, fields, t)
+	return infc.NewPoint(name, addExtraTags(tags), fields, t)
 }
 
 // SendPoint sends point to the channel listened by metrics consumer
 func SendPoint(p *infc.Point) {
+	// Each point sent by parser saves its timestamp for use as a closing point.
+	// Saving it here instead of collector makes it final as soon as parser stops,
+	// so closing points do not depend on how fast the collector is.
+	// Log lines are not strictly ordered, so the latest timestamp is kept
+	if p.Time().After(lastPoint) {
+		lastPoint = p.Time()
+	}
 	pc <- p
 }
 
-func sendBatch(points []*infc.Point) {
-	const retries = 5
-
-	bp, _ := infc.NewBatchPoints(infc.BatchPointsConfig{
-		Precision: "ns",
-		Database:  dbName,
-	})
-	bp.AddPoints(points)
-
-	// Retry mechanism for batch points sending
-	var errCounter int
-SendLoop:
-	for {
-		err := c.Write(bp)
-		if err != nil {
-			l.Errorf("Error sending points batch to InfluxDB: %v\n", err)
-			errCounter++
-			if errCounter == retries {
-				l.Errorf("Failed to send %d points as batch to server\n", len(points))
-				return
-			}
-			time.Sleep(2 * time.Second)
-		}
-		break SendLoop
+// SinksStats returns amounts of points delivered to or lost for each sink
+func SinksStats() []SinkStats {
+	stats := make([]SinkStats, 0, len(sinks))
+	for _, w := range sinks {
+		stats = append(stats, w.stats())
 	}
 
-	if errCounter > 0 {
-		l.Infof("%d points successfully sent after %d retries\n", len(points), errCounter)
-		return
-	}
-
-	l.Debugf("Successfully written %d points to DB\n", len(points))
+	return stats
 }
 
 // SendUserLineData takes a line with user data and adds it to the processing list
@@ -136,57 +152,157 @@ func SendUserLineData(timestamp time.Time, scenario, status string) {
 }
 
 func sendUserData(m map[string]users, ts time.Time) ([]*client.Point, error) {
-	// Prepare points
-	points := make([]*client.Point, 0, len(m))
+	// Prepare points, including a total for all scenarios
+	points := make([]*client.Point, 0, len(m)+1)
+	var total users
 	for k, v := range m {
-		point, err := client.NewPoint(
-			"users",
-			map[string]string{
-				"scenario": k,
-				"testId":   info.testID,
-				"nodeName": info.nodeName,
-			},
-			map[string]interface{}{
-				"active": v.active,
-				"started": v.started,
-				"ended": v.ended,
-			},
-			ts,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("Error creating new point with user data: %w", err)
+		total.active += v.active
+		total.started += v.started
+		total.ended += v.ended
+		total.reportedStarted += v.reportedStarted
+
+		// Scenario with the name of totals would be mixed up with them in the same series,
+		// so its users are reported as part of totals only
+		if k == allScenarios {
+			allScenarioWarning.Do(func() {
+				l.Errorf("Users of scenario named %q are reported as part of totals for all scenarios only\n", allScenarios)
+			})
+		} else {
+			point, err := newUsersPoint(k, v, ts)
+			if err != nil {
+				return nil, err
+			}
+			points = append(points, point)
 		}
 
-		points = append(points, point)
+		v.reportedStarted = v.started
+		m[k] = v
+	}
 
+	if len(m) == 0 {
+		return points, nil
+	}
+	point, err := newUsersPoint(allScenarios, total, ts)
+	if err != nil {
+		return nil, err
 	}
 
-	return points, nil
+	return append(points, point), nil
+}
+
+func newUsersPoint(scenario string, v users, ts time.Time) (*client.Point, error) {
+	point, err := NewPoint(
+		"users",
+		map[string]string{
+			"scenario": scenario,
+			"testId":   info.testID,
+			"nodeName": info.nodeName,
+		},
+		map[string]interface{}{
+			"active":  v.active,
+			"started": v.started,
+			"ended":   v.ended,
+			// Users started per second since previous send
+			"arrivalRate": float64(v.started-v.reportedStarted) / usersInterval.Seconds(),
+		},
+		ts,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating new point with user data: %w", err)
+	}
+
+	return point, nil
 }
 
 func usersProcessor(ctx context.Context, wg *sync.WaitGroup) {
-	// Send current user state to database each N seconds
-	const timeRangeLen = 1
+	// Send current user state to database each usersInterval
 	defer wg.Done()
 
-	// Workaround:
 	// Wait for testInfo to fill
-	for {
-		if !info.testStartTime.IsZero() {
-			break
+	select {
+	case <-testInfoReady:
+	case <-ctx.Done():
+		// Parser is stopped at this moment, so short imports may have test info
+		// filled while waiting. Otherwise no RUN line is found and there is nothing to send
+		select {
+		case <-testInfoReady:
+		default:
+			return
 		}
-		time.Sleep(time.Second)
 	}
 
 	secondFrom := info.testStartTime.Round(time.Second)
-	secondTo := secondFrom.Add(time.Second * timeRangeLen)
+	secondTo := secondFrom.Add(usersInterval)
 	usersMap := make(map[string]users)
 
+	processUserLine := func(p userLineData) {
+		for {
+			// If point is somehow from the past
+			if p.timestamp.Before(secondFrom) {
+				// Then we just update the map
+				usersMapValues := usersMap[p.scenario]
+
+				switch p.status {
+				case "START":
+					usersMapValues.active++
+					usersMapValues.started++
+				case "END":
+					usersMapValues.active--
+					usersMapValues.ended++
+				}
+
+				usersMap[p.scenario] = usersMapValues
+
+				return
+			}
+
+			// TODO: May combine with previous one later
+			// If timestamp is a part of the current time range
+			if (p.timestamp.After(secondFrom) || p.timestamp.Equal(secondFrom)) && p.timestamp.Before(secondTo) {
+				// We update the map
+				usersMapValues := usersMap[p.scenario]
+
+				switch p.status {
+				case "START":
+					usersMapValues.active++
+					usersMapValues.started++
+				case "END":
+					usersMapValues.active--
+					usersMapValues.ended++
+				}
+
+				usersMap[p.scenario] = usersMapValues
+
+				return
+			}
+
+			// Else we assume this time range is done and advance searching range for next N seconds
+			secondFrom, secondTo = secondTo, secondTo.Add(usersInterval)
+
+			// And send data for previous range
+			points, err := sendUserData(usersMap, secondFrom)
+			if err != nil {
+				l.Errorf("Failed to send user data: %v", err)
+				continue
+			}
+			for _, p := range points {
+				pc <- p
+			}
+
+			// Loop is then advanced looking for suitable range
+		}
+	}
+
 CollectorLoop:
 	for {
 		select {
 		// If an external cancellation signal is received
 		case <-ctx.Done():
+			// Process user lines that are still buffered in the channel
+			for len(uc) > 0 {
+				processUserLine(<-uc)
+			}
+
 			// Init closeup
 			closingPointTime := lastPoint
 			var points []*client.Point
@@ -194,7 +310,7 @@ CollectorLoop:
 			// Last point in buffer should always be sent. So this is an imitation of do-while loop
 			for {
 				// Advance searching range for next N seconds
-				secondFrom, secondTo = secondTo, secondTo.Add(time.Second*timeRangeLen)
+				secondFrom, secondTo = secondTo, secondTo.Add(usersInterval)
 
 				// Collect remaining points
 				pts, err := sendUserData(usersMap, secondFrom)
@@ -209,117 +325,45 @@ CollectorLoop:
 					break
 				}
 			}
-			// If total amount of points is higher than allowed batch amount
-			// it is split and sent in batches
-			for len(points) > int(maxPoints) {
-				sendBatch(points[:int(maxPoints)])
-				points = points[int(maxPoints):]
+			// Collector is still running at this moment and takes care of batching
+			for _, p := range points {
+				pc <- p
 			}
-			sendBatch(points)
 
 			break CollectorLoop
 
 		// On each new user line data
 		case p := <-uc:
-		SearcherLoop:
-			for {
-				// If point is somehow from the past
-				if p.timestamp.Before(secondFrom) {
-					// Then we just update the map
-					usersMapValues := usersMap[p.scenario]
-
-					switch p.status {
-					case "START":
-						usersMapValues.active++
-						usersMapValues.started++
-					case "END":
-						usersMapValues.active--
-						usersMapValues.ended++
-					}
-
-                    usersMap[p.scenario] = usersMapValues
-
-					break SearcherLoop
-				}
-
-				// TODO: May combine with previous one later
-				// If timestamp is a part of the current time range
-				if (p.timestamp.After(secondFrom) || p.timestamp.Equal(secondFrom)) && p.timestamp.Before(secondTo) {
-					// We update the map
-					usersMapValues := usersMap[p.scenario]
-
-					switch p.status {
-					case "START":
-						usersMapValues.active++
-						usersMapValues.started++
-					case "END":
-						usersMapValues.active--
-						usersMapValues.ended++
-					}
-
-                    usersMap[p.scenario] = usersMapValues
-
-					break SearcherLoop
-				}
-
-				// Else we assume this time range is done and advance searching range for next N seconds
-				secondFrom, secondTo = secondTo, secondTo.Add(time.Second*timeRangeLen)
-
-				// And send data for previous range
-				points, err := sendUserData(usersMap, secondFrom)
-				if err != nil {
-					l.Errorf("Failed to send user data: %v", err)
-					continue
-				}
-				for _, p := range points {
-					pc <- p
-				}
-
-				// Loop is then advanced looking for suitable range
-			}
+			processUserLine(p)
 		}
 	}
 }
 
+// metricsPointsCollector receives all points and passes them to each sink queue
 func metricsPointsCollector(ctx context.Context, wg *sync.WaitGroup) {
 	defer wg.Done()
-	points := make([]*infc.Point, 0, int(maxPoints))
 
-	timer := time.NewTimer(time.Second * time.Duration(writeDataTimeout))
+	dispatch := func(p *infc.Point) {
+		for _, w := range sinks {
+			w.send(p)
+		}
+	}
+
 CollectorLoop:
 	for {
 		select {
-		// Send points after timer expires
-		case <-timer.C:
-			if len(points) > 0 {
-				sendBatch(points)
-				// After sending points to server clear points buffer
-				points = make([]*infc.Point, 0, int(maxPoints))
-			}
-			// Reset timer
-			timer.Reset(time.Second * time.Duration(writeDataTimeout))
 		// When point is received on the channel
 		case p := <-pc:
-			points = append(points, p)
-			// Send batch points when batch capacity is reached
-			if len(points) == int(maxPoints) {
-				sendBatch(points)
-				// After sending points to server clear points buffer
-				points = make([]*infc.Point, 0, maxPoints)
-				// Reset timer
-				timer.Reset(time.Second * time.Duration(writeDataTimeout))
-			}
-			// Each point received saves its timestamp for use as a closing point
-			// Don't use users data because it has aggregated time stamp instead of concrete one
-			if p.Name() != "users" {
-				lastPoint = p.Time()
-			}
+			dispatch(p)
 		// Await for external stop signal
 		case <-ctx.Done():
-			// Send any unsent points
-			if len(points) > 0 {
-				sendBatch(points)
-				points = make([]*infc.Point, 0, int(maxPoints))
+			// Pass points that are still buffered in the channel
+			for len(pc) > 0 {
+				dispatch(<-pc)
+			}
+			// Closing queues makes sink workers send any unsent points and stop
+			for _, w := range sinks {
+				w.close()
 			}
 			break CollectorLoop
 		}
@@ -335,13 +379,14 @@ func sendClosingPoint() {
 	}
 
 	// Create a point signifying a test end
-	p, _ := infc.NewPoint(
+	p, _ := NewPoint(
 		"tests",
 		map[string]string{
-			"action":     "end",
-			"simulation": info.simulationName,
-			"testId":     info.testID,
-			"nodeName":   info.nodeName,
+			"action":         "end",
+			"simulation":     info.simulationName,
+			"testId":         info.testID,
+			"nodeName":       info.nodeName,
+			"gatlingVersion": info.gatlingVersion,
 		},
 		map[string]interface{}{
 			"description": info.description,
@@ -350,7 +395,10 @@ func sendClosingPoint() {
 		lastPoint.Add(time.Second*5),
 	)
 
-	sendBatch([]*infc.Point{p})
+	pc <- p
+
+	sendSummaryPoints(lastPoint.Add(time.Second * 5))
+	evaluateSLA(lastPoint.Add(time.Second * 5))
 }
 
 // StartProcessing starts consumers that receive points from parser and send to
@@ -361,11 +409,21 @@ func StartProcessing(ctx context.Context, owg *sync.WaitGroup) {
 	l.Infoln("Starting consumers for parser results")
 	wg := &sync.WaitGroup{}
 
+	// start sink workers, each of them sends points to its own backend
+	swg := &sync.WaitGroup{}
+	for _, w := range sinks {
+		swg.Add(1)
+		go w.run(swg)
+	}
+
 	// start requests consumer
+	upWg := &sync.WaitGroup{}
 	upCtx, upCancel := context.WithCancel(context.Background())
 	mpcCtx, mpcCancel := context.WithCancel(context.Background())
-	wg.Add(2)
-	go usersProcessor(upCtx, wg)
+	upWg.Add(2)
+	wg.Add(1)
+	go usersProcessor(upCtx, upWg)
+	go requestsAggregator(upCtx, upWg)
 	go metricsPointsCollector(mpcCtx, wg)
 
 	// Wait for external stop signal
@@ -373,34 +431,71 @@ func StartProcessing(ctx context.Context, owg *sync.WaitGroup) {
 
 	l.Infoln("Stopping all points processor...")
 	upCancel()
+	// Users processor and aggregator still send points to the collector, so they should be stopped first
+	upWg.Wait()
+	sendClosingPoint()
 	mpcCancel() // This should be the last one
 
 	wg.Wait()
-	sendClosingPoint()
+	// Wait for sinks to send all queued points
+	swg.Wait()
 	l.Infoln("Points processor finished")
 
-	err := CloseDBConnection()
-	if err != nil {
-		l.Errorf("Failed to close DB connection: %v", err)
+	for _, w := range sinks {
+		if err := w.sink.Close(); err != nil {
+			l.Errorf("Failed to close %s sink: %v", w.sink.Name(), err)
+		}
 	}
 }
 
 // InitInfluxConnection establishes connection to InfluxDB database
 // and checks if it is successful
 func InitInfluxConnection(cmd *cobra.Command) error {
-	username, _ := cmd.Flags().GetString("username")
-	password, _ := cmd.Flags().GetString("password")
 	address, _ := cmd.Flags().GetString("address")
-	dbName, _ = cmd.Flags().GetString("database")
+	apiVersion, _ := cmd.Flags().GetString("api-version")
 	maxPoints, _ = cmd.Flags().GetUint("max-batch-size")
 	detached, _ := cmd.Flags().GetBool("detached")
+	userAgent := fmt.Sprintf("g2i-http-client-%s(%s)", cmd.Version, runtime.Version())
+
+	var err error
+	switch {
+	case address == "":
+		// InfluxDB output is disabled, other sinks may still be used
+		return nil
+	case strings.HasPrefix(address, "udp://"):
+		err = initUDPConnection(cmd, strings.TrimPrefix(address, "udp://"))
+	case apiVersion == "v1":
+		err = initV1Connection(cmd, address, userAgent)
+	case apiVersion == "v2":
+		err = initV2Connection(cmd, address, userAgent)
+	default:
+		return fmt.Errorf("Unknown InfluxDB API version %q, must be v1 or v2", apiVersion)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !detached {
+		l.Infof("Connection with InfluxDB at %s successfully established\n", address)
+		return nil
+	}
+
+	return CloseDBConnection()
+}
+
+// initV1Connection creates a client for InfluxDB 1.x and checks that database
+// is accessible with provided credentials
+func initV1Connection(cmd *cobra.Command, address, userAgent string) error {
+	username, _ := cmd.Flags().GetString("username")
+	password, _ := cmd.Flags().GetString("password")
+	dbName, _ = cmd.Flags().GetString("database")
 
 	var err error
 	c, err = infc.NewHTTPClient(infc.HTTPConfig{
 		Addr:      address,
 		Username:  username,
 		Password:  password,
-		UserAgent: fmt.Sprintf("g2i-http-client-%s(%s)", cmd.Version, runtime.Version()),
+		UserAgent: userAgent,
 		Timeout:   time.Second * 60,
 	})
 	if err != nil {
@@ -418,15 +513,122 @@ func InitInfluxConnection(cmd *cobra.Command) error {
 	if err := res.Error(); err != nil {
 		return fmt.Errorf("Test query failed with error: %w", err)
 	}
-	if !detached {
-		l.Infof("Connection with InfluxDB at %s successfully established\n", address)
-		return nil
+
+	return nil
+}
+
+// initUDPConnection creates a client writing to InfluxDB UDP listener. Database is configured
+// on the listener side, and there is no way to check if server receives packets
+func initUDPConnection(cmd *cobra.Command, address string) error {
+	payloadSize, _ := cmd.Flags().GetUint("udp-payload-size")
+
+	var err error
+	c, err = newUDPClient(address, int(payloadSize))
+	if err != nil {
+		return fmt.Errorf("Connection with InfluxDB at %s could not be established. Error: %w", address, err)
 	}
 
-	return CloseDBConnection()
+	return nil
+}
+
+// initV2Connection creates a client for InfluxDB 2.x and 3.x write API and checks
+// that bucket is accessible with provided token
+func initV2Connection(cmd *cobra.Command, address, userAgent string) error {
+	org, _ := cmd.Flags().GetString("org")
+	bucket, _ := cmd.Flags().GetString("bucket")
+	token, _ := cmd.Flags().GetString("token")
+
+	v2c, err := newV2Client(address, org, bucket, token, userAgent, time.Second*60)
+	if err != nil {
+		return err
+	}
+	c = v2c
+
+	if err := v2c.checkConnection(time.Second * 10); err != nil {
+		return fmt.Errorf("Connection with InfluxDB at %s could not be established. Error: %w", address, err)
+	}
+
+	return nil
+}
+
+// InitMeasurements sets up intervals, histogram buckets and extra tags
+// of measurements computed from log
+func InitMeasurements(cmd *cobra.Command) error {
+	usersIntervalSec, _ := cmd.Flags().GetUint("users-interval")
+	if usersIntervalSec == 0 {
+		return errors.New("Users interval must be at least 1 second")
+	}
+	usersInterval = time.Duration(usersIntervalSec) * time.Second
+	aggIntervalSec, _ := cmd.Flags().GetUint("agg-interval")
+	aggInterval = time.Duration(aggIntervalSec) * time.Second
+	buckets, _ := cmd.Flags().GetIntSlice("histogram-buckets")
+	logBuckets, _ := cmd.Flags().GetIntSlice("histogram-log-buckets")
+	if err := initHistogramBuckets(buckets, logBuckets); err != nil {
+		return err
+	}
+	tags, _ := cmd.Flags().GetStringArray("tag")
+	envTags, _ := cmd.Flags().GetStringArray("tag-env")
+
+	return initTags(tags, envTags)
+}
+
+// InitSinks creates workers for all configured outputs. InfluxDB output uses
+// a client created by InitInfluxConnection, if any
+func InitSinks(cmd *cobra.Command) error {
+	outputFile, _ := cmd.Flags().GetString("output-file")
+	promAddress, _ := cmd.Flags().GetString("prometheus-listen")
+	promLinger, _ := cmd.Flags().GetUint("prometheus-linger")
+	// Metrics of one-shot import are not scraped after it is finished
+	if cmd.Name() == "import" {
+		promLinger = 0
+	}
+	spoolDir, _ := cmd.Flags().GetString("spool-dir")
+	spoolMaxSize, _ := cmd.Flags().GetUint("spool-max-size")
+	writeConcurrency, _ := cmd.Flags().GetUint("write-concurrency")
+
+	// Queue of each sink may hold several batches, so short delays of
+	// a backend do not cause points to be dropped
+	queueSize := 10 * int(maxPoints)
+	if c != nil {
+		w := newSinkWorker(&clientSink{client: c, database: dbName}, queueSize, int(writeConcurrency))
+		if spoolDir != "" {
+			sp, err := openSpool(filepath.Join(spoolDir, w.sink.Name()), int64(spoolMaxSize)<<20)
+			if err != nil {
+				return err
+			}
+			w.spool = sp
+		}
+		sinks = append(sinks, w)
+	}
+	if outputFile != "" {
+		fs, err := newFileSink(outputFile)
+		if err != nil {
+			return err
+		}
+		sinks = append(sinks, newSinkWorker(fs, queueSize, 1))
+		l.Infof("Points will be written to file %s\n", outputFile)
+	}
+
+	if promAddress != "" {
+		ps, err := newPromSink(promAddress, time.Duration(promLinger)*time.Second)
+		if err != nil {
+			return err
+		}
+		sinks = append(sinks, newSinkWorker(ps, queueSize, 1))
+		l.Infof("Prometheus metrics are served at %s/metrics\n", promAddress)
+	}
+
+	if len(sinks) == 0 {
+		return errors.New("No outputs configured, provide InfluxDB address, output file or Prometheus listen address")
+	}
+
+	return nil
 }
 
 // CloseDBConnection just closes a connection to database when called
 func CloseDBConnection() error {
+	if c == nil {
+		return nil
+	}
 	return c.Close()
 }
@@ -0,0 +1,74 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package influx
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSendUserData(t *testing.T) {
+	defer func(v time.Duration) { usersInterval = v }(usersInterval)
+	usersInterval = 2 * time.Second
+
+	m := map[string]users{
+		"all":    {active: 2, started: 4, ended: 2},
+		"Search": {active: 1, started: 3, ended: 2, reportedStarted: 1},
+	}
+	points, err := sendUserData(m, testTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Scenario named as totals is reported as part of them only
+	if len(points) != 2 {
+		t.Fatalf("expected 2 points, got %d", len(points))
+	}
+
+	totals := 0
+	for _, p := range points {
+		if p.Name() != "users" {
+			t.Errorf("unexpected measurement %s", p.Name())
+			continue
+		}
+		fields, _ := p.Fields()
+		switch p.Tags()["scenario"] {
+		case "Search":
+			if fields["active"] != int64(1) || fields["arrivalRate"] != 1.0 {
+				t.Errorf("unexpected scenario fields %v", fields)
+			}
+		case allScenarios:
+			totals++
+			if fields["active"] != int64(3) || fields["started"] != int64(7) || fields["ended"] != int64(4) || fields["arrivalRate"] != 3.0 {
+				t.Errorf("unexpected totals %v", fields)
+			}
+		default:
+			t.Errorf("unexpected scenario tag %v", p.Tags())
+		}
+	}
+	if totals != 1 {
+		t.Errorf("expected a single total point, got %d", totals)
+	}
+	if m["Search"].reportedStarted != 3 {
+		t.Errorf("reported started amount is not updated: %+v", m["Search"])
+	}
+}
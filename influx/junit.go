@@ -0,0 +1,255 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package influx
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	l "github.com/dakaraj/gatling-to-influxdb/logger"
+)
+
+// assertionsFileName is a file with results of Gatling assertions written
+// to results directory when Gatling generates its HTML report
+const assertionsFileName = "js/assertions.json"
+
+var (
+	// declaredAssertions is an amount of assertions declared by simulation in log header
+	declaredAssertions int
+	// assertionsWait is how long to wait for assertions file when log declares assertions,
+	// as Gatling may still be generating its report when the test ends
+	assertionsWait = 10 * time.Second
+)
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitTestSuite struct {
+	XMLName   xml.Name        `xml:"testsuite"`
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      float64         `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+// gatlingAssertions is a part of assertions file needed for the report
+type gatlingAssertions struct {
+	Assertions []struct {
+		Path    string `json:"path"`
+		Message string `json:"message"`
+		Result  bool   `json:"result"`
+	} `json:"assertions"`
+}
+
+// addFailure adds a failure reason to a test case, all reasons are put into a single failure
+func (tc *junitTestCase) addFailure(failureType, message string) {
+	if tc.Failure == nil {
+		tc.Failure = &junitFailure{Message: message, Type: failureType, Text: message}
+		return
+	}
+	tc.Failure.Text += "\n" + message
+}
+
+// slaFailures adds failures of SLA checks of provided scope, name and groups to a test case
+// and marks these checks as reported
+func slaFailures(tc *junitTestCase, reported []bool, scope, name, groups string) {
+	for i, res := range slaResults {
+		if res.Scope != scope || res.Name != name || res.Groups != groups {
+			continue
+		}
+		reported[i] = true
+		if !res.Passed {
+			tc.addFailure("SLA", fmt.Sprintf("SLA %s failed, actual value %v", res.Rule, res.Actual))
+		}
+	}
+}
+
+// SetDeclaredAssertions sets an amount of assertions declared by simulation in log header.
+// Their results are only known from assertions file written with Gatling report
+func SetDeclaredAssertions(n int) {
+	declaredAssertions = n
+}
+
+// readAssertions reads Gatling assertions from results directory, missing file means no assertions.
+// If log declares assertions, the file is waited for a while
+func readAssertions(resultsDir string) (*gatlingAssertions, error) {
+	path := filepath.Join(resultsDir, assertionsFileName)
+	deadline := time.Now().Add(assertionsWait)
+	data, err := ioutil.ReadFile(path)
+	for os.IsNotExist(err) && declaredAssertions > 0 && time.Now().Before(deadline) {
+		time.Sleep(500 * time.Millisecond)
+		data, err = ioutil.ReadFile(path)
+	}
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	a := &gatlingAssertions{}
+	if err := json.Unmarshal(data, a); err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %w", assertionsFileName, err)
+	}
+
+	return a, nil
+}
+
+// WriteJUnitReport writes a JUnit XML report with a test case for each request and group
+// seen during the test. Test case fails if its KO ratio is above maxKORatio (%) or any of
+// its SLA checks failed. Gatling assertions found in results directory are added as well,
+// assertions declared in log without results are added as skipped test cases.
+// It should be called only after processing of points is finished
+func WriteJUnitReport(path, resultsDir string, maxKORatio float64) error {
+	if info.testStartTime.IsZero() {
+		return fmt.Errorf("Test did not start, JUnit report is not written")
+	}
+
+	keys := make([]summaryKey, 0, len(summaries))
+	for k := range summaries {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].isGroup != keys[j].isGroup {
+			return !keys[i].isGroup
+		}
+		if keys[i].groups != keys[j].groups {
+			return keys[i].groups < keys[j].groups
+		}
+		return keys[i].name < keys[j].name
+	})
+
+	reported := make([]bool, len(slaResults))
+	suite := junitTestSuite{
+		Name:      info.simulationName,
+		Time:      lastPoint.Sub(info.testStartTime).Seconds(),
+		Timestamp: info.testStartTime.UTC().Format("2006-01-02T15:04:05"),
+	}
+	for _, k := range keys {
+		s := summaries[k]
+		h := s.durations
+		scope, className := slaScopeRequest, info.simulationName+".requests"
+		if k.isGroup {
+			scope, className = slaScopeGroup, info.simulationName+".groups"
+		}
+		tc := junitTestCase{
+			Name:      strings.TrimSpace(k.groups + " " + k.name),
+			ClassName: className,
+			Time:      float64(h.sum) / 1000,
+			SystemOut: fmt.Sprintf("count: %d, KO: %d, min: %d, mean: %.1f, p50: %d, p95: %d, p99: %d, max: %d",
+				h.count, s.koCount, h.min, h.mean(), h.valueAt(50), h.valueAt(95), h.valueAt(99), h.max),
+		}
+		if koRatio := float64(s.koCount) / float64(h.count) * 100; s.koCount > 0 && koRatio > maxKORatio {
+			tc.addFailure("KO", fmt.Sprintf("%d of %d executions failed (%.2f%%)", s.koCount, h.count, koRatio))
+		}
+		slaFailures(&tc, reported, scope, k.name, k.groups)
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+
+	// Global SLA checks and checks of requests or groups that were never executed
+	for i, res := range slaResults {
+		if reported[i] {
+			continue
+		}
+		tc := junitTestCase{Name: res.Rule, ClassName: info.simulationName + ".sla"}
+		if !res.Passed {
+			tc.addFailure("SLA", fmt.Sprintf("SLA %s failed, actual value %v", res.Rule, res.Actual))
+		}
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+
+	assertions, err := readAssertions(resultsDir)
+	if err != nil {
+		return err
+	}
+	if assertions != nil {
+		for _, a := range assertions.Assertions {
+			tc := junitTestCase{Name: a.Message, ClassName: info.simulationName + ".assertions"}
+			if !a.Result {
+				tc.addFailure("assertion", a.Message)
+			}
+			suite.TestCases = append(suite.TestCases, tc)
+		}
+	} else {
+		for i := 1; i <= declaredAssertions; i++ {
+			suite.TestCases = append(suite.TestCases, junitTestCase{
+				Name:      fmt.Sprintf("assertion %d", i),
+				ClassName: info.simulationName + ".assertions",
+				Skipped:   &junitSkipped{Message: "Assertion result is unknown, " + assertionsFileName + " was not found in results directory"},
+			})
+		}
+	}
+
+	suite.Tests = len(suite.TestCases)
+	for _, tc := range suite.TestCases {
+		if tc.Failure != nil {
+			suite.Failures++
+		}
+		if tc.Skipped != nil {
+			suite.Skipped++
+		}
+	}
+
+	data, err := xml.MarshalIndent(junitTestSuites{Suites: []junitTestSuite{suite}}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Failed to build JUnit report: %w", err)
+	}
+	if err := ioutil.WriteFile(path, append([]byte(xml.Header), append(data, '\n')...), 0644); err != nil {
+		return fmt.Errorf("Failed to write JUnit report: %w", err)
+	}
+	l.Infof("JUnit report is written to %s: %d test cases, %d failures\n", path, suite.Tests, suite.Failures)
+
+	return nil
+}
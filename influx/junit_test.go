@@ -0,0 +1,170 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package influx
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestJUnitReport writes a report for results directory and parses it back
+func writeTestJUnitReport(t *testing.T, resultsDir string, maxKORatio float64) junitTestSuite {
+	path := filepath.Join(resultsDir, "junit.xml")
+	if err := WriteJUnitReport(path, resultsDir, maxKORatio); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report junitTestSuites
+	if err := xml.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Suites) != 1 {
+		t.Fatalf("expected a single test suite, got %d", len(report.Suites))
+	}
+
+	return report.Suites[0]
+}
+
+// testCases returns test cases of a suite by their class and name
+func testCases(suite junitTestSuite) map[string]junitTestCase {
+	cases := make(map[string]junitTestCase)
+	for _, tc := range suite.TestCases {
+		cases[tc.ClassName+" "+tc.Name] = tc
+	}
+
+	return cases
+}
+
+func TestWriteJUnitReport(t *testing.T) {
+	defer resetSLA()
+	dir, err := ioutil.TempDir("", "g2i-junit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	info = testInfo{simulationName: "Sim", testStartTime: testTime}
+	lastPoint = testTime.Add(90 * time.Second)
+	for _, r := range []requestLineData{
+		{name: "home", result: "OK", duration: 100},
+		{name: "search", groups: "find", result: "OK", duration: 200},
+		{name: "search", groups: "find", result: "KO", duration: 300},
+		{name: "find", result: "OK", duration: 600, isGroup: true},
+	} {
+		addToSummary(r)
+	}
+	value := 250.0
+	slaRules = []slaRule{{Scope: slaScopeRequest, Metric: "max", Op: "<", Value: &value}}
+	slaResults = []SLAResult{
+		{Rule: `request "*" max < 250`, Scope: slaScopeRequest, Name: "home", Metric: "max", Actual: 100, Threshold: 250, Passed: true},
+		{Rule: `request "*" max < 250`, Scope: slaScopeRequest, Name: "search", Groups: "find", Metric: "max", Actual: 300, Threshold: 250},
+		{Rule: "global errorRate < 1", Scope: slaScopeGlobal, Metric: "errorRate", Actual: 33, Threshold: 1},
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "js"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	assertions := `{"assertions": [{"path": "Global", "message": "Global: max of response time is less than 1000", "result": true},
+{"path": "Global", "message": "Global: percentage of failed events is less than 1.0", "result": false}]}`
+	if err := ioutil.WriteFile(filepath.Join(dir, assertionsFileName), []byte(assertions), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	suite := writeTestJUnitReport(t, dir, 50)
+
+	if suite.Name != "Sim" || suite.Time != 90 || suite.Tests != 6 || suite.Failures != 3 || suite.Skipped != 0 {
+		t.Errorf("unexpected suite %+v", suite)
+	}
+	cases := testCases(suite)
+	for name, failed := range map[string]bool{
+		"Sim.requests home":            false,
+		"Sim.requests find search":     true,
+		"Sim.groups find":              false,
+		"Sim.sla global errorRate < 1": true,
+		"Sim.assertions Global: max of response time is less than 1000":       false,
+		"Sim.assertions Global: percentage of failed events is less than 1.0": true,
+	} {
+		tc, ok := cases[name]
+		if !ok {
+			t.Errorf("no test case %q in %v", name, cases)
+			continue
+		}
+		if (tc.Failure != nil) != failed {
+			t.Errorf("%q: failure = %+v, want failed %v", name, tc.Failure, failed)
+		}
+	}
+
+	// KO ratio above the limit fails a test case
+	suite = writeTestJUnitReport(t, dir, 10)
+	if tc := testCases(suite)["Sim.requests find search"]; tc.Failure == nil || tc.Failure.Type != "KO" {
+		t.Errorf("expected KO failure, got %+v", tc.Failure)
+	}
+}
+
+func TestJUnitReportDeclaredAssertions(t *testing.T) {
+	defer resetSLA()
+	defer func(wait time.Duration) {
+		assertionsWait = wait
+		declaredAssertions = 0
+	}(assertionsWait)
+	dir, err := ioutil.TempDir("", "g2i-junit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	info = testInfo{simulationName: "Sim", testStartTime: testTime}
+	lastPoint = testTime
+	SetDeclaredAssertions(2)
+	assertionsWait = 0
+
+	suite := writeTestJUnitReport(t, dir, 0)
+	if suite.Tests != 2 || suite.Skipped != 2 || suite.Failures != 0 {
+		t.Errorf("unexpected suite %+v", suite)
+	}
+
+	// Assertions file written a bit later is picked up
+	assertionsWait = 5 * time.Second
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		os.MkdirAll(filepath.Join(dir, "js"), 0755)
+		ioutil.WriteFile(filepath.Join(dir, assertionsFileName), []byte(`{"assertions": [{"message": "a", "result": true}, {"message": "b", "result": true}]}`), 0644)
+	}()
+	suite = writeTestJUnitReport(t, dir, 0)
+	if suite.Tests != 2 || suite.Skipped != 0 || suite.Failures != 0 {
+		t.Errorf("unexpected suite %+v", suite)
+	}
+}
+
+func TestJUnitReportWithoutTestStart(t *testing.T) {
+	defer resetSLA()
+	if err := WriteJUnitReport(filepath.Join(os.TempDir(), "g2i-junit.xml"), os.TempDir(), 0); err == nil {
+		t.Error("expected error when test did not start")
+	}
+}
@@ -0,0 +1,289 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package influx
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	l "github.com/dakaraj/gatling-to-influxdb/logger"
+	infc "github.com/influxdata/influxdb1-client/v2"
+)
+
+// promBuckets are upper bounds (seconds) of response time histogram buckets
+var promBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// promRequestKey identifies a set of labels of request metrics
+type promRequestKey struct {
+	testID string
+	name   string
+	group  string
+	result string
+}
+
+// promUsersKey identifies a set of labels of users metrics
+type promUsersKey struct {
+	testID   string
+	scenario string
+}
+
+// promHistogram is a cumulative histogram of response times
+type promHistogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *promHistogram) observe(v float64) {
+	for i, b := range promBuckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// promSink keeps metrics computed from points and exposes them in Prometheus
+// text format. Endpoint is served until the sink is closed on application shutdown
+// and for linger period after that, so final numbers can be scraped
+type promSink struct {
+	server   *http.Server
+	linger   time.Duration
+	mu       sync.Mutex
+	requests map[promRequestKey]*promHistogram
+	users    map[promUsersKey]users
+	// allUsers holds users totals of all scenarios by test ID. They are exported
+	// as separate metrics, so aggregation of per scenario series does not count them twice
+	allUsers map[string]users
+}
+
+func newPromSink(address string, linger time.Duration) (*promSink, error) {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to listen for Prometheus metrics at %s: %w", address, err)
+	}
+
+	s := &promSink{
+		linger:   linger,
+		requests: make(map[promRequestKey]*promHistogram),
+		users:    make(map[promUsersKey]users),
+		allUsers: make(map[string]users),
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s)
+	s.server = &http.Server{Handler: mux}
+
+	go func() {
+		if err := s.server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			l.Errorf("Prometheus metrics endpoint stopped with error: %v\n", err)
+		}
+	}()
+
+	return s, nil
+}
+
+func (s *promSink) Name() string {
+	return "prometheus"
+}
+
+// Write updates metrics with requests and users points, all other points are ignored
+func (s *promSink) Write(points []*infc.Point) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range points {
+		switch p.Name() {
+		case "requests":
+			fields, err := p.Fields()
+			if err != nil {
+				continue
+			}
+			duration, ok := fields["duration"].(int64)
+			if !ok {
+				continue
+			}
+			tags := p.Tags()
+			key := promRequestKey{
+				testID: tags["testId"],
+				name:   tags["name"],
+				group:  tags["groups"],
+				result: tags["result"],
+			}
+			h, ok := s.requests[key]
+			if !ok {
+				h = &promHistogram{counts: make([]uint64, len(promBuckets))}
+				s.requests[key] = h
+			}
+			h.observe(float64(duration) / 1000)
+		case "users":
+			fields, err := p.Fields()
+			if err != nil {
+				continue
+			}
+			tags := p.Tags()
+			active, _ := fields["active"].(int64)
+			started, _ := fields["started"].(int64)
+			ended, _ := fields["ended"].(int64)
+			u := users{active: int(active), started: int(started), ended: int(ended)}
+			if tags["scenario"] == allScenarios {
+				s.allUsers[tags["testId"]] = u
+				continue
+			}
+			s.users[promUsersKey{testID: tags["testId"], scenario: tags["scenario"]}] = u
+		}
+	}
+
+	return nil
+}
+
+// Close stops serving metrics endpoint after linger period
+func (s *promSink) Close() error {
+	if s.linger > 0 {
+		l.Infof("Serving final Prometheus metrics for %v before exit\n", s.linger)
+		time.Sleep(s.linger)
+	}
+
+	return s.server.Close()
+}
+
+// promLabels formats label pairs escaping their values
+func promLabels(pairs ...string) string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(pairs[i])
+		b.WriteString(`="`)
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(pairs[i+1])
+		b.WriteString(v)
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+
+	return b.String()
+}
+
+func promFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// ServeHTTP writes all metrics in Prometheus text exposition format
+func (s *promSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	requestKeys := make([]promRequestKey, 0, len(s.requests))
+	for k := range s.requests {
+		requestKeys = append(requestKeys, k)
+	}
+	sort.Slice(requestKeys, func(i, j int) bool {
+		a, b := requestKeys[i], requestKeys[j]
+		if a.testID != b.testID {
+			return a.testID < b.testID
+		}
+		if a.name != b.name {
+			return a.name < b.name
+		}
+		if a.group != b.group {
+			return a.group < b.group
+		}
+		return a.result < b.result
+	})
+
+	fmt.Fprintln(bw, "# HELP g2i_request_duration_seconds Response time of requests")
+	fmt.Fprintln(bw, "# TYPE g2i_request_duration_seconds histogram")
+	for _, k := range requestKeys {
+		h := s.requests[k]
+		for i, b := range promBuckets {
+			fmt.Fprintf(bw, "g2i_request_duration_seconds_bucket%s %d\n",
+				promLabels("test_id", k.testID, "name", k.name, "group", k.group, "result", k.result, "le", promFloat(b)), h.counts[i])
+		}
+		labels := promLabels("test_id", k.testID, "name", k.name, "group", k.group, "result", k.result)
+		fmt.Fprintf(bw, "g2i_request_duration_seconds_bucket%s %d\n",
+			promLabels("test_id", k.testID, "name", k.name, "group", k.group, "result", k.result, "le", "+Inf"), h.count)
+		fmt.Fprintf(bw, "g2i_request_duration_seconds_sum%s %s\n", labels, promFloat(h.sum))
+		fmt.Fprintf(bw, "g2i_request_duration_seconds_count%s %d\n", labels, h.count)
+	}
+
+	fmt.Fprintln(bw, "# HELP g2i_requests_total Amount of completed requests by result")
+	fmt.Fprintln(bw, "# TYPE g2i_requests_total counter")
+	for _, k := range requestKeys {
+		labels := promLabels("test_id", k.testID, "name", k.name, "group", k.group, "result", k.result)
+		fmt.Fprintf(bw, "g2i_requests_total%s %d\n", labels, s.requests[k].count)
+	}
+
+	usersKeys := make([]promUsersKey, 0, len(s.users))
+	for k := range s.users {
+		usersKeys = append(usersKeys, k)
+	}
+	sort.Slice(usersKeys, func(i, j int) bool {
+		a, b := usersKeys[i], usersKeys[j]
+		if a.testID != b.testID {
+			return a.testID < b.testID
+		}
+		return a.scenario < b.scenario
+	})
+
+	allUsersKeys := make([]string, 0, len(s.allUsers))
+	for k := range s.allUsers {
+		allUsersKeys = append(allUsersKeys, k)
+	}
+	sort.Strings(allUsersKeys)
+
+	usersMetrics := []struct {
+		name, help, kind string
+		value            func(u users) int
+	}{
+		{"users_active", "Amount of currently active users", "gauge", func(u users) int { return u.active }},
+		{"users_started_total", "Amount of started users", "counter", func(u users) int { return u.started }},
+		{"users_ended_total", "Amount of ended users", "counter", func(u users) int { return u.ended }},
+	}
+	for _, m := range usersMetrics {
+		fmt.Fprintf(bw, "# HELP g2i_%s %s by scenario\n", m.name, m.help)
+		fmt.Fprintf(bw, "# TYPE g2i_%s %s\n", m.name, m.kind)
+		for _, k := range usersKeys {
+			fmt.Fprintf(bw, "g2i_%s%s %d\n", m.name, promLabels("test_id", k.testID, "scenario", k.scenario), m.value(s.users[k]))
+		}
+	}
+	for _, m := range usersMetrics {
+		fmt.Fprintf(bw, "# HELP g2i_all_%s %s of all scenarios\n", m.name, m.help)
+		fmt.Fprintf(bw, "# TYPE g2i_all_%s %s\n", m.name, m.kind)
+		for _, k := range allUsersKeys {
+			fmt.Fprintf(bw, "g2i_all_%s%s %d\n", m.name, promLabels("test_id", k), m.value(s.allUsers[k]))
+		}
+	}
+}
@@ -0,0 +1,121 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package influx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	infc "github.com/influxdata/influxdb1-client/v2"
+)
+
+func TestPromExposition(t *testing.T) {
+	s, err := newPromSink("127.0.0.1:0", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	newPoint := func(name string, tags map[string]string, fields map[string]interface{}) *infc.Point {
+		p, err := infc.NewPoint(name, tags, fields, testTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	points := []*infc.Point{
+		newPoint("requests", map[string]string{"testId": "t1", "name": `say "hi"`, "groups": "", "result": "OK"}, map[string]interface{}{"duration": 20}),
+		newPoint("requests", map[string]string{"testId": "t1", "name": `say "hi"`, "groups": "", "result": "OK"}, map[string]interface{}{"duration": 700}),
+		newPoint("requests", map[string]string{"testId": "t1", "name": "buy", "groups": "shop", "result": "KO"}, map[string]interface{}{"duration": 15000}),
+		newPoint("users", map[string]string{"testId": "t1", "scenario": "Search"}, map[string]interface{}{"active": 3, "started": 5, "ended": 2}),
+		newPoint("users", map[string]string{"testId": "t1", "scenario": "all"}, map[string]interface{}{"active": 3, "started": 5, "ended": 2}),
+		newPoint("errors", map[string]string{"testId": "t1"}, map[string]interface{}{"count": 1}),
+	}
+	if err := s.Write(points); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	want := `# HELP g2i_request_duration_seconds Response time of requests
+# TYPE g2i_request_duration_seconds histogram
+g2i_request_duration_seconds_bucket{test_id="t1",name="buy",group="shop",result="KO",le="0.005"} 0
+g2i_request_duration_seconds_bucket{test_id="t1",name="buy",group="shop",result="KO",le="0.01"} 0
+g2i_request_duration_seconds_bucket{test_id="t1",name="buy",group="shop",result="KO",le="0.025"} 0
+g2i_request_duration_seconds_bucket{test_id="t1",name="buy",group="shop",result="KO",le="0.05"} 0
+g2i_request_duration_seconds_bucket{test_id="t1",name="buy",group="shop",result="KO",le="0.1"} 0
+g2i_request_duration_seconds_bucket{test_id="t1",name="buy",group="shop",result="KO",le="0.25"} 0
+g2i_request_duration_seconds_bucket{test_id="t1",name="buy",group="shop",result="KO",le="0.5"} 0
+g2i_request_duration_seconds_bucket{test_id="t1",name="buy",group="shop",result="KO",le="1"} 0
+g2i_request_duration_seconds_bucket{test_id="t1",name="buy",group="shop",result="KO",le="2.5"} 0
+g2i_request_duration_seconds_bucket{test_id="t1",name="buy",group="shop",result="KO",le="5"} 0
+g2i_request_duration_seconds_bucket{test_id="t1",name="buy",group="shop",result="KO",le="10"} 0
+g2i_request_duration_seconds_bucket{test_id="t1",name="buy",group="shop",result="KO",le="+Inf"} 1
+g2i_request_duration_seconds_sum{test_id="t1",name="buy",group="shop",result="KO"} 15
+g2i_request_duration_seconds_count{test_id="t1",name="buy",group="shop",result="KO"} 1
+g2i_request_duration_seconds_bucket{test_id="t1",name="say \"hi\"",group="",result="OK",le="0.005"} 0
+g2i_request_duration_seconds_bucket{test_id="t1",name="say \"hi\"",group="",result="OK",le="0.01"} 0
+g2i_request_duration_seconds_bucket{test_id="t1",name="say \"hi\"",group="",result="OK",le="0.025"} 1
+g2i_request_duration_seconds_bucket{test_id="t1",name="say \"hi\"",group="",result="OK",le="0.05"} 1
+g2i_request_duration_seconds_bucket{test_id="t1",name="say \"hi\"",group="",result="OK",le="0.1"} 1
+g2i_request_duration_seconds_bucket{test_id="t1",name="say \"hi\"",group="",result="OK",le="0.25"} 1
+g2i_request_duration_seconds_bucket{test_id="t1",name="say \"hi\"",group="",result="OK",le="0.5"} 1
+g2i_request_duration_seconds_bucket{test_id="t1",name="say \"hi\"",group="",result="OK",le="1"} 2
+g2i_request_duration_seconds_bucket{test_id="t1",name="say \"hi\"",group="",result="OK",le="2.5"} 2
+g2i_request_duration_seconds_bucket{test_id="t1",name="say \"hi\"",group="",result="OK",le="5"} 2
+g2i_request_duration_seconds_bucket{test_id="t1",name="say \"hi\"",group="",result="OK",le="10"} 2
+g2i_request_duration_seconds_bucket{test_id="t1",name="say \"hi\"",group="",result="OK",le="+Inf"} 2
+g2i_request_duration_seconds_sum{test_id="t1",name="say \"hi\"",group="",result="OK"} 0.72
+g2i_request_duration_seconds_count{test_id="t1",name="say \"hi\"",group="",result="OK"} 2
+# HELP g2i_requests_total Amount of completed requests by result
+# TYPE g2i_requests_total counter
+g2i_requests_total{test_id="t1",name="buy",group="shop",result="KO"} 1
+g2i_requests_total{test_id="t1",name="say \"hi\"",group="",result="OK"} 2
+# HELP g2i_users_active Amount of currently active users by scenario
+# TYPE g2i_users_active gauge
+g2i_users_active{test_id="t1",scenario="Search"} 3
+# HELP g2i_users_started_total Amount of started users by scenario
+# TYPE g2i_users_started_total counter
+g2i_users_started_total{test_id="t1",scenario="Search"} 5
+# HELP g2i_users_ended_total Amount of ended users by scenario
+# TYPE g2i_users_ended_total counter
+g2i_users_ended_total{test_id="t1",scenario="Search"} 2
+# HELP g2i_all_users_active Amount of currently active users of all scenarios
+# TYPE g2i_all_users_active gauge
+g2i_all_users_active{test_id="t1"} 3
+# HELP g2i_all_users_started_total Amount of started users of all scenarios
+# TYPE g2i_all_users_started_total counter
+g2i_all_users_started_total{test_id="t1"} 5
+# HELP g2i_all_users_ended_total Amount of ended users of all scenarios
+# TYPE g2i_all_users_ended_total counter
+g2i_all_users_ended_total{test_id="t1"} 2
+`
+	if got := rec.Body.String(); got != want {
+		t.Errorf("unexpected exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,575 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package influx
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	l "github.com/dakaraj/gatling-to-influxdb/logger"
+	infc "github.com/influxdata/influxdb1-client/v2"
+)
+
+// Sink is a backend that receives batches of points produced by parser
+type Sink interface {
+	// Name is used to identify sink in logs and statistics
+	Name() string
+	// Write delivers a batch of points. Returned error triggers a retry
+	// unless it is a partial write error
+	Write(points []*infc.Point) error
+	// Close releases sink resources after all points are written
+	Close() error
+}
+
+// permanentError is returned by sinks when server rejects a batch itself, e.g. because
+// of a field type conflict or a malformed line. Such batch is never retried or spooled
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// isPermanentStatus reports if HTTP status code means that the same request will be rejected
+// again. All client errors are permanent except too many requests
+func isPermanentStatus(code int) bool {
+	return code >= 400 && code < 500 && code != 429
+}
+
+const (
+	// spoolReplayInterval is a minimal interval between attempts to replay spooled batches
+	spoolReplayInterval = 5 * time.Second
+	// maxPendingBatches is an amount of batches that may wait for each writer
+	maxPendingBatches = 4
+)
+
+// backpressure makes collector wait for sinks with full queues instead of dropping points
+var backpressure bool
+
+// EnableBackpressure makes outputs slow down parsing instead of dropping points when
+// their queues are full. Useful when log is already written and there is no need to hurry
+func EnableBackpressure() {
+	backpressure = true
+}
+
+// SinkStats contains amounts of points delivered to or lost for a sink
+// and amount of batches left in its spool
+type SinkStats struct {
+	Name    string `json:"name"`
+	Written uint64 `json:"written"`
+	Failed  uint64 `json:"failed"`
+	Spooled int64  `json:"spooled"`
+}
+
+// sinkWorker owns a queue of points for a single sink and sends them in batches.
+// Each sink has its own worker, so a slow or failing sink does not stall others.
+// Batches are written by a pool of writers, each of them handles its own subset
+// of series, so points of the same series are always delivered in order
+type sinkWorker struct {
+	sink        Sink
+	queue       chan *infc.Point
+	concurrency int
+
+	written uint64
+	failed  uint64
+	// dropped counts points rejected because queue was full since last report
+	dropped uint64
+	spooled int64
+
+	// spool is optional, when set batches that could not be delivered are kept on disk.
+	// It is shared by all writers and replayer, so it is guarded by a mutex
+	spoolMu sync.Mutex
+	spool   *spool
+	// overflow collects points for spool while queue is full, it is only accessed by collector
+	overflow []*infc.Point
+}
+
+// newSinkWorker creates a worker for a sink. Sinks that are not safe for concurrent
+// writes must use concurrency of 1
+func newSinkWorker(s Sink, queueSize, concurrency int) *sinkWorker {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	return &sinkWorker{
+		sink:        s,
+		queue:       make(chan *infc.Point, queueSize),
+		concurrency: concurrency,
+	}
+}
+
+// send puts a point to the sink queue without blocking, so a slow sink can not stall
+// the points collector. If the queue is full, points of a sink with spool are spooled
+// and points of other sinks are dropped, unless backpressure makes them wait for the queue
+func (w *sinkWorker) send(p *infc.Point) {
+	if w.spool != nil {
+		w.sendOrSpool(p)
+		return
+	}
+	if backpressure {
+		w.queue <- p
+		return
+	}
+
+	select {
+	case w.queue <- p:
+	default:
+		atomic.AddUint64(&w.dropped, 1)
+		atomic.AddUint64(&w.failed, 1)
+	}
+}
+
+// sendOrSpool queues a point of a sink with spool. While the queue is full, points are
+// collected into a batch that is spooled as soon as it is full, so a hanging server
+// stalls neither the collector nor other sinks. Points queued before such batch may be
+// delivered after it, which is harmless as every point carries its own timestamp
+func (w *sinkWorker) sendOrSpool(p *infc.Point) {
+	if len(w.overflow) == 0 {
+		select {
+		case w.queue <- p:
+			return
+		default:
+		}
+	}
+	w.overflow = append(w.overflow, p)
+	if len(w.overflow) >= int(maxPoints) {
+		w.flushOverflow()
+	}
+}
+
+// flushOverflow spools points collected while the queue was full
+func (w *sinkWorker) flushOverflow() {
+	if len(w.overflow) == 0 {
+		return
+	}
+	w.spoolMu.Lock()
+	w.spoolBatch(w.overflow)
+	w.spoolMu.Unlock()
+	w.overflow = nil
+}
+
+// close spools points left after queue overflow and closes the queue,
+// so worker sends any unsent points and stops
+func (w *sinkWorker) close() {
+	w.flushOverflow()
+	close(w.queue)
+}
+
+func (w *sinkWorker) stats() SinkStats {
+	return SinkStats{
+		Name:    w.sink.Name(),
+		Written: atomic.LoadUint64(&w.written),
+		Failed:  atomic.LoadUint64(&w.failed),
+		Spooled: atomic.LoadInt64(&w.spooled),
+	}
+}
+
+func (w *sinkWorker) reportDropped() {
+	if d := atomic.SwapUint64(&w.dropped, 0); d > 0 {
+		l.Errorf("Queue of %s sink is full, dropped %d points\n", w.sink.Name(), d)
+	}
+}
+
+// lane picks a writer for a point by its series, so all points of the same series
+// are written by the same writer
+func (w *sinkWorker) lane(p *infc.Point) int {
+	if w.concurrency == 1 {
+		return 0
+	}
+
+	tags := p.Tags()
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := fnv.New32a()
+	h.Write([]byte(p.Name()))
+	for _, k := range keys {
+		h.Write([]byte{','})
+		h.Write([]byte(k))
+		h.Write([]byte{'='})
+		h.Write([]byte(tags[k]))
+	}
+
+	return int(h.Sum32() % uint32(w.concurrency))
+}
+
+// run collects points from the queue into batches per writer and passes them to writers
+// when batch is full or write timeout expires. It returns after the queue is closed
+// and all batches are written
+func (w *sinkWorker) run(wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	// Spooled batches are replayed in background, so a server that hangs
+	// does not stop collecting points. Batches left by previous runs are replayed
+	// on the first replay interval
+	var stopReplay chan struct{}
+	rwg := &sync.WaitGroup{}
+	if w.spool != nil {
+		if w.spool.len() > 0 {
+			w.reportSpool()
+		}
+		stopReplay = make(chan struct{})
+		rwg.Add(1)
+		go w.replayer(stopReplay, rwg)
+	}
+
+	// Start writers, each with a bounded queue of pending batches
+	lanes := make([]chan []*infc.Point, w.concurrency)
+	buffers := make([][]*infc.Point, w.concurrency)
+	lwg := &sync.WaitGroup{}
+	for i := range lanes {
+		lanes[i] = make(chan []*infc.Point, maxPendingBatches)
+		buffers[i] = make([]*infc.Point, 0, int(maxPoints))
+		lwg.Add(1)
+		go func(batches <-chan []*infc.Point) {
+			defer lwg.Done()
+			for points := range batches {
+				w.writeBatch(points)
+			}
+		}(lanes[i])
+	}
+	flush := func(i int) {
+		if len(buffers[i]) > 0 {
+			lanes[i] <- buffers[i]
+			// After passing points to writer clear points buffer
+			buffers[i] = make([]*infc.Point, 0, int(maxPoints))
+		}
+	}
+
+	timer := time.NewTimer(time.Second * time.Duration(writeDataTimeout))
+	defer timer.Stop()
+	for {
+		select {
+		// Send points after timer expires
+		case <-timer.C:
+			for i := range buffers {
+				flush(i)
+			}
+			w.reportDropped()
+			// Reset timer
+			timer.Reset(time.Second * time.Duration(writeDataTimeout))
+		// When point is received on the queue
+		case p, ok := <-w.queue:
+			if !ok {
+				// Send any unsent points and wait for writers to finish
+				for i := range buffers {
+					flush(i)
+					close(lanes[i])
+				}
+				lwg.Wait()
+				w.reportDropped()
+				// Make a last attempt to deliver the backlog regardless of replay interval
+				if w.spool != nil {
+					close(stopReplay)
+					rwg.Wait()
+					if !w.replaySpool() {
+						l.Errorf("%d batches remain in spool of %s sink and will be replayed on next start\n", w.spool.len(), w.sink.Name())
+					}
+				}
+				return
+			}
+			i := w.lane(p)
+			buffers[i] = append(buffers[i], p)
+			// Pass batch points to writer when batch capacity is reached
+			if len(buffers[i]) == int(maxPoints) {
+				flush(i)
+			}
+		}
+	}
+}
+
+// writeBatch writes points to the sink. If the sink has a spool, batches that could
+// not be delivered are spooled after the first failed attempt and replayed later
+// in the same order, so writers are not blocked by retries during an outage
+func (w *sinkWorker) writeBatch(points []*infc.Point) {
+	attempts := 5
+	if w.spool != nil {
+		attempts = 1
+		w.spoolMu.Lock()
+		// New batch can only be written after older spooled ones to keep the order,
+		// so it goes straight to the spool while replayer delivers the backlog
+		if w.spool.len() > 0 {
+			w.spoolBatch(points)
+			w.spoolMu.Unlock()
+			return
+		}
+		w.spoolMu.Unlock()
+	}
+	if w.deliver(points, attempts) {
+		return
+	}
+	if w.spool != nil {
+		w.spoolMu.Lock()
+		w.spoolBatch(points)
+		w.spoolMu.Unlock()
+		return
+	}
+	atomic.AddUint64(&w.failed, uint64(len(points)))
+}
+
+// deliver writes points to the sink making up to provided amount of attempts.
+// Returns false if all attempts failed and batch may be delivered later
+func (w *sinkWorker) deliver(points []*infc.Point, attempts int) bool {
+	name := w.sink.Name()
+	// Retry mechanism for batch points sending
+	var errCounter int
+	for {
+		err := w.sink.Write(points)
+		// Sinks without delivery guarantees are not retried
+		var pwe *partialWriteError
+		if errors.As(err, &pwe) {
+			l.Errorf("Failed to send %d of %d points to %s: %v\n", pwe.failed, len(points), name, pwe.err)
+			atomic.AddUint64(&w.failed, uint64(pwe.failed))
+			atomic.AddUint64(&w.written, uint64(len(points)-pwe.failed))
+			return true
+		}
+		var pe *permanentError
+		if errors.As(err, &pe) {
+			l.Errorf("%s rejected batch of %d points: %v\n", name, len(points), pe.err)
+			atomic.AddUint64(&w.failed, uint64(len(points)))
+			return true
+		}
+		if err == nil {
+			break
+		}
+
+		l.Errorf("Error sending points batch to %s: %v\n", name, err)
+		errCounter++
+		if errCounter == attempts {
+			l.Errorf("Failed to send %d points as batch to %s\n", len(points), name)
+			return false
+		}
+		time.Sleep(2 * time.Second)
+	}
+	atomic.AddUint64(&w.written, uint64(len(points)))
+
+	if errCounter > 0 {
+		l.Infof("%d points successfully sent to %s after %d retries\n", len(points), name, errCounter)
+		return true
+	}
+
+	l.Debugf("Successfully written %d points to %s\n", len(points), name)
+
+	return true
+}
+
+// spoolBatch stores a batch that could not be delivered in the spool.
+// Must be called with spool mutex locked
+func (w *sinkWorker) spoolBatch(points []*infc.Point) {
+	if err := w.spool.push(points); err != nil {
+		l.Errorf("Failed to spool %d points for %s: %v\n", len(points), w.sink.Name(), err)
+		atomic.AddUint64(&w.failed, uint64(len(points)))
+		return
+	}
+	w.reportSpool()
+}
+
+func (w *sinkWorker) reportSpool() {
+	atomic.StoreInt64(&w.spooled, int64(w.spool.len()))
+	l.Infof("Spool of %s sink holds %d batches (%d bytes)\n", w.sink.Name(), w.spool.len(), w.spool.size)
+}
+
+// replayer replays spooled batches every replay interval until stop channel is closed
+func (w *sinkWorker) replayer(stop <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(spoolReplayInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			w.replaySpool()
+		}
+	}
+}
+
+// replaySpool tries to deliver spooled batches oldest first, making a single attempt
+// for each of them. Batches rejected by server are discarded. Returns true if spool is
+// empty afterwards. Spool mutex is only held to access the spool, so writers may spool
+// new batches meanwhile. Must not be called concurrently
+func (w *sinkWorker) replaySpool() bool {
+	name := w.sink.Name()
+	var replayed int
+	for {
+		w.spoolMu.Lock()
+		if w.spool.len() == 0 {
+			w.spoolMu.Unlock()
+			break
+		}
+		points, err := w.spool.peek()
+		w.spoolMu.Unlock()
+
+		var pe *permanentError
+		if err != nil {
+			l.Errorf("Discarding spooled batch of %s sink: %v\n", name, err)
+		} else if err := w.sink.Write(points); errors.As(err, &pe) {
+			l.Errorf("%s rejected spooled batch of %d points: %v\n", name, len(points), pe.err)
+			atomic.AddUint64(&w.failed, uint64(len(points)))
+		} else if err != nil {
+			l.Errorf("Failed to replay spooled batch to %s: %v\n", name, err)
+			w.spoolMu.Lock()
+			w.reportSpool()
+			w.spoolMu.Unlock()
+			return false
+		} else {
+			atomic.AddUint64(&w.written, uint64(len(points)))
+			replayed++
+		}
+
+		w.spoolMu.Lock()
+		err = w.spool.pop()
+		if err != nil {
+			l.Errorf("Failed to remove spooled batch of %s sink: %v\n", name, err)
+			w.reportSpool()
+		}
+		w.spoolMu.Unlock()
+		if err != nil {
+			return false
+		}
+	}
+	atomic.StoreInt64(&w.spooled, 0)
+	if replayed > 0 {
+		l.Infof("Spool of %s sink is fully replayed, %d batches delivered\n", name, replayed)
+	}
+
+	return true
+}
+
+// clientSink writes points using InfluxDB client of any supported transport
+type clientSink struct {
+	client   infc.Client
+	database string
+}
+
+func (s *clientSink) Name() string {
+	return "influxdb"
+}
+
+func (s *clientSink) Write(points []*infc.Point) error {
+	bp, err := infc.NewBatchPoints(infc.BatchPointsConfig{
+		Precision: "ns",
+		Database:  s.database,
+	})
+	if err != nil {
+		return err
+	}
+	bp.AddPoints(points)
+
+	err = s.client.Write(bp)
+	if err != nil && isV1PermanentError(err) {
+		return &permanentError{err: err}
+	}
+
+	return err
+}
+
+// v1PermanentErrors are messages of InfluxDB 1.x client error responses to writes.
+// HTTP client of 1.x API does not expose response status, so they are recognized by text
+var v1PermanentErrors = []string{
+	"partial write",
+	"unable to parse",
+	"database not found",
+	"authorization failed",
+	"request entity too large",
+}
+
+func isV1PermanentError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, m := range v1PermanentErrors {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *clientSink) Close() error {
+	return s.client.Close()
+}
+
+// fileSink writes points as line protocol to a local file
+type fileSink struct {
+	file *os.File
+	w    *bufio.Writer
+}
+
+func newFileSink(path string) (*fileSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("Failed to create directory for output file: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot create output file at %s: %w", path, err)
+	}
+
+	return &fileSink{
+		file: file,
+		w:    bufio.NewWriter(file),
+	}, nil
+}
+
+func (s *fileSink) Name() string {
+	return "file"
+}
+
+func (s *fileSink) Write(points []*infc.Point) error {
+	for _, p := range points {
+		if _, err := s.w.WriteString(p.PrecisionString("ns")); err != nil {
+			return err
+		}
+		if err := s.w.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+
+	return s.w.Flush()
+}
+
+func (s *fileSink) Close() error {
+	if err := s.w.Flush(); err != nil {
+		s.file.Close()
+		return err
+	}
+
+	return s.file.Close()
+}
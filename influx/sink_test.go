@@ -0,0 +1,416 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package influx
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	l "github.com/dakaraj/gatling-to-influxdb/logger"
+	infc "github.com/influxdata/influxdb1-client/v2"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "g2i-test")
+	if err != nil {
+		panic(err)
+	}
+	if err := l.InitLogger(filepath.Join(dir, "test.log")); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// fakeSink returns queued errors for writes, and records successfully written batches
+type fakeSink struct {
+	errs    []error
+	writes  int
+	batches [][]*infc.Point
+}
+
+func (s *fakeSink) Name() string {
+	return "fake"
+}
+
+func (s *fakeSink) Write(points []*infc.Point) error {
+	s.writes++
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		if err != nil {
+			return err
+		}
+	}
+	s.batches = append(s.batches, points)
+	return nil
+}
+
+func (s *fakeSink) Close() error {
+	return nil
+}
+
+func testPoints(t *testing.T, n int) []*infc.Point {
+	points := make([]*infc.Point, 0, n)
+	for i := 0; i < n; i++ {
+		p, err := infc.NewPoint("m", map[string]string{"t": "v"}, map[string]interface{}{"i": i}, testTime.Add(time.Duration(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		points = append(points, p)
+	}
+	return points
+}
+
+var testTime = time.Unix(1596196277, 0)
+
+// newSpooledWorker creates a worker with spool in a temporary directory,
+// returned function removes it
+func newSpooledWorker(t *testing.T, s Sink) (*sinkWorker, func()) {
+	dir, err := ioutil.TempDir("", "g2i-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sp, err := openSpool(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := newSinkWorker(s, 10, 1)
+	w.spool = sp
+	return w, func() { os.RemoveAll(dir) }
+}
+
+func TestPermanentErrorIsNotRetried(t *testing.T) {
+	s := &fakeSink{errs: []error{&permanentError{err: errors.New("field type conflict")}}}
+	w, cleanup := newSpooledWorker(t, s)
+	defer cleanup()
+
+	w.writeBatch(testPoints(t, 3))
+
+	if s.writes != 1 {
+		t.Errorf("expected a single write attempt, got %d", s.writes)
+	}
+	if st := w.stats(); st.Failed != 3 || st.Written != 0 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+	if w.spool.len() != 0 {
+		t.Errorf("rejected batch must not be spooled, spool holds %d", w.spool.len())
+	}
+}
+
+func TestReplayDiscardsRejectedBatch(t *testing.T) {
+	s := &fakeSink{}
+	w, cleanup := newSpooledWorker(t, s)
+	defer cleanup()
+	for i := 1; i <= 3; i++ {
+		if err := w.spool.push(testPoints(t, i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.errs = []error{nil, &permanentError{err: errors.New("unable to parse")}, nil}
+	if !w.replaySpool() {
+		t.Fatal("expected spool to be fully replayed")
+	}
+	if w.spool.len() != 0 {
+		t.Errorf("spool still holds %d batches", w.spool.len())
+	}
+	if len(s.batches) != 2 || len(s.batches[0]) != 1 || len(s.batches[1]) != 3 {
+		t.Errorf("unexpected batches delivered: %v", s.batches)
+	}
+	if st := w.stats(); st.Failed != 2 || st.Written != 4 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+
+	// Other errors keep the batch in spool
+	if err := w.spool.push(testPoints(t, 1)); err != nil {
+		t.Fatal(err)
+	}
+	s.errs = []error{errors.New("timeout")}
+	if w.replaySpool() || w.spool.len() != 1 {
+		t.Errorf("batch failed with temporary error must stay in spool")
+	}
+}
+
+func TestPermanentErrorClassification(t *testing.T) {
+	for code, want := range map[int]bool{400: true, 401: true, 404: true, 413: true, 429: false, 500: false, 503: false} {
+		if got := isPermanentStatus(code); got != want {
+			t.Errorf("isPermanentStatus(%d) = %v, want %v", code, got, want)
+		}
+	}
+	for msg, want := range map[string]bool{
+		`{"error":"partial write: field type conflict"}`:     true,
+		`{"error":"unable to parse 'm f=': missing fields"}`: true,
+		`{"error":"timeout"}`:                                false,
+		`dial tcp: connection refused`:                       false,
+	} {
+		if got := isV1PermanentError(errors.New(msg)); got != want {
+			t.Errorf("isV1PermanentError(%q) = %v, want %v", msg, got, want)
+		}
+	}
+}
+
+func TestBatchIsSpooledBehindBacklog(t *testing.T) {
+	s := &fakeSink{}
+	w, cleanup := newSpooledWorker(t, s)
+	defer cleanup()
+	if err := w.spool.push(testPoints(t, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	w.writeBatch(testPoints(t, 2))
+
+	if s.writes != 0 {
+		t.Errorf("batch must not be written while spool has backlog, got %d writes", s.writes)
+	}
+	if w.spool.len() != 2 {
+		t.Fatalf("expected batch to be spooled behind backlog, spool holds %d", w.spool.len())
+	}
+	if !w.replaySpool() {
+		t.Fatal("expected spool to be fully replayed")
+	}
+	if len(s.batches) != 2 || len(s.batches[0]) != 1 || len(s.batches[1]) != 2 {
+		t.Errorf("batches are replayed out of order: %v", s.batches)
+	}
+}
+
+func TestRunReplaysSpoolOnClose(t *testing.T) {
+	maxPoints = 10
+	s := &fakeSink{}
+	w, cleanup := newSpooledWorker(t, s)
+	defer cleanup()
+	if err := w.spool.push(testPoints(t, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go w.run(wg)
+	for _, p := range testPoints(t, 3) {
+		w.send(p)
+	}
+	close(w.queue)
+	wg.Wait()
+
+	if w.spool.len() != 0 {
+		t.Errorf("spool still holds %d batches", w.spool.len())
+	}
+	if st := w.stats(); st.Written != 4 || st.Failed != 0 || st.Spooled != 0 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+}
+
+func TestFailedBatchIsSpooledAfterFirstAttempt(t *testing.T) {
+	s := &fakeSink{errs: []error{errors.New("connection refused")}}
+	w, cleanup := newSpooledWorker(t, s)
+	defer cleanup()
+
+	start := time.Now()
+	w.writeBatch(testPoints(t, 2))
+
+	if s.writes != 1 {
+		t.Errorf("expected a single write attempt, got %d", s.writes)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("batch was spooled after %v, expected no retry delay", d)
+	}
+	if w.spool.len() != 1 {
+		t.Errorf("expected batch to be spooled, spool holds %d", w.spool.len())
+	}
+}
+
+func TestSpooledSinkDoesNotDropPoints(t *testing.T) {
+	defer func(v uint) { maxPoints = v }(maxPoints)
+	maxPoints = 4
+
+	// Worker of a hanging sink never takes points from its queue of 10 points
+	hung, cleanup := newSpooledWorker(t, &fakeSink{})
+	defer cleanup()
+	s := &fakeSink{}
+	defer func(v []*sinkWorker) { sinks = v }(sinks)
+	sinks = []*sinkWorker{hung, newSinkWorker(s, 100, 1)}
+	swg := &sync.WaitGroup{}
+	swg.Add(1)
+	go sinks[1].run(swg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go metricsPointsCollector(ctx, wg)
+	points := testPoints(t, 25)
+	for _, p := range points {
+		pc <- p
+	}
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		swg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("collector is stalled by a hanging sink with spool")
+	}
+
+	var written int
+	for _, b := range s.batches {
+		written += len(b)
+	}
+	if written != len(points) {
+		t.Errorf("other sink received %d of %d points", written, len(points))
+	}
+	// 15 points that did not fit the queue are spooled in batches of 4 and the rest on close
+	if st := hung.stats(); st.Failed != 0 || hung.spool.len() != 4 || len(hung.queue) != 10 {
+		t.Errorf("points were dropped: %+v, spool holds %d batches, queue %d points", st, hung.spool.len(), len(hung.queue))
+	}
+}
+
+// concurrentSink records written points and is safe for concurrent writes.
+// Writes wait until gate is closed, if it is set
+type concurrentSink struct {
+	mu     sync.Mutex
+	gate   chan struct{}
+	writes int
+	points []*infc.Point
+}
+
+func (s *concurrentSink) Name() string {
+	return "concurrent"
+}
+
+func (s *concurrentSink) Write(points []*infc.Point) error {
+	if s.gate != nil {
+		<-s.gate
+	}
+	// Let other writers run, so batches of different lanes interleave
+	time.Sleep(time.Duration(len(points)%3) * time.Millisecond)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writes++
+	s.points = append(s.points, points...)
+	return nil
+}
+
+func (s *concurrentSink) Close() error {
+	return nil
+}
+
+// seriesPoints creates points of several series, each of them with increasing seq field
+func seriesPoints(t *testing.T, series, perSeries int) []*infc.Point {
+	points := make([]*infc.Point, 0, series*perSeries)
+	for i := 0; i < perSeries; i++ {
+		for s := 0; s < series; s++ {
+			p, err := infc.NewPoint("m", map[string]string{"series": string(rune('a' + s))}, map[string]interface{}{"seq": i}, testTime)
+			if err != nil {
+				t.Fatal(err)
+			}
+			points = append(points, p)
+		}
+	}
+	return points
+}
+
+func TestConcurrentWritersKeepSeriesOrder(t *testing.T) {
+	defer func(v uint) { maxPoints = v }(maxPoints)
+	maxPoints = 3
+
+	s := &concurrentSink{}
+	w := newSinkWorker(s, 1000, 4)
+	points := seriesPoints(t, 10, 50)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go w.run(wg)
+	for _, p := range points {
+		w.send(p)
+	}
+	// Points left in buffers are flushed when queue is closed
+	close(w.queue)
+	wg.Wait()
+
+	if len(s.points) != len(points) {
+		t.Fatalf("expected %d points written, got %d", len(points), len(s.points))
+	}
+	if st := w.stats(); st.Written != uint64(len(points)) || st.Failed != 0 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+	last := make(map[string]int64)
+	for _, p := range s.points {
+		series := p.Tags()["series"]
+		fields, _ := p.Fields()
+		seq := fields["seq"].(int64)
+		if prev, ok := last[series]; ok && seq <= prev {
+			t.Fatalf("series %s: point %d written after %d", series, seq, prev)
+		}
+		last[series] = seq
+	}
+	lanes := make(map[int]bool)
+	for _, p := range points[:10] {
+		lanes[w.lane(p)] = true
+	}
+	if len(lanes) < 2 {
+		t.Errorf("series are not distributed between writers: %v", lanes)
+	}
+}
+
+func TestPendingBatchesAreBounded(t *testing.T) {
+	defer func(v uint) { maxPoints = v }(maxPoints)
+	maxPoints = 1
+
+	s := &concurrentSink{gate: make(chan struct{})}
+	const concurrency = 2
+	w := newSinkWorker(s, 100, concurrency)
+	points := seriesPoints(t, 10, 5)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go w.run(wg)
+	for _, p := range points {
+		w.send(p)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// Each writer holds a batch being written and a bounded queue of pending ones,
+	// and collector holds a single point it can not pass to a full queue
+	taken := len(points) - len(w.queue)
+	if max := concurrency*(maxPendingBatches+1) + 1; taken > max {
+		t.Errorf("collector took %d points while sink is blocked, expected at most %d", taken, max)
+	}
+
+	close(s.gate)
+	close(w.queue)
+	wg.Wait()
+	if len(s.points) != len(points) {
+		t.Errorf("expected %d points written after sink is unblocked, got %d", len(points), len(s.points))
+	}
+}
@@ -0,0 +1,280 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package influx
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dakaraj/gatling-to-influxdb/config"
+	l "github.com/dakaraj/gatling-to-influxdb/logger"
+)
+
+// Scopes of SLA rules
+const (
+	slaScopeGlobal  = "global"
+	slaScopeRequest = "request"
+	slaScopeGroup   = "group"
+)
+
+// slaRule is a single threshold for a metric of all requests, of requests
+// or groups with provided name, or of each request or group if name is empty
+type slaRule struct {
+	Scope  string   `json:"scope"`
+	Name   string   `json:"name"`
+	Metric string   `json:"metric"`
+	Op     string   `json:"op"`
+	Value  *float64 `json:"value"`
+}
+
+func (r slaRule) String() string {
+	if r.Scope == slaScopeGlobal {
+		return fmt.Sprintf("%s %s %s %v", r.Scope, r.Metric, r.Op, *r.Value)
+	}
+	name := r.Name
+	if name == "" {
+		name = "*"
+	}
+
+	return fmt.Sprintf("%s %q %s %s %v", r.Scope, name, r.Metric, r.Op, *r.Value)
+}
+
+// SLAResult is an outcome of checking one SLA rule against one request or group
+type SLAResult struct {
+	Rule      string
+	Scope     string
+	Name      string
+	Groups    string
+	Metric    string
+	Actual    float64
+	Threshold float64
+	Passed    bool
+}
+
+var (
+	slaRules   []slaRule
+	slaResults []SLAResult
+
+	slaOps = map[string]func(a, b float64) bool{
+		"<":  func(a, b float64) bool { return a < b },
+		"<=": func(a, b float64) bool { return a <= b },
+		">":  func(a, b float64) bool { return a > b },
+		">=": func(a, b float64) bool { return a >= b },
+	}
+)
+
+// InitSLA loads SLA rules from a JSON, YAML or TOML file. Empty path disables SLA checks.
+// Unknown keys and missing values are reported, as a misspelled rule would never fail or never pass
+func InitSLA(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	var file struct {
+		Rules []slaRule `json:"rules"`
+	}
+	if err := config.DecodeFileStrict(path, &file); err != nil {
+		return fmt.Errorf("Failed to load SLA rules: %w", err)
+	}
+	if len(file.Rules) == 0 {
+		return fmt.Errorf("No SLA rules found in %s", path)
+	}
+
+	for i, r := range file.Rules {
+		if r.Scope == "" {
+			r.Scope = slaScopeGlobal
+		}
+		switch r.Scope {
+		case slaScopeGlobal, slaScopeRequest, slaScopeGroup:
+		default:
+			return fmt.Errorf("SLA rule %d: unknown scope %q, must be global, request or group", i+1, r.Scope)
+		}
+		if r.Value == nil {
+			return fmt.Errorf("SLA rule %d: value is required", i+1)
+		}
+		if _, ok := slaOps[r.Op]; !ok {
+			return fmt.Errorf("SLA rule %d: unknown operator %q, must be one of <, <=, >, >=", i+1, r.Op)
+		}
+		if _, err := slaMetric(r.Metric, &summaryData{durations: newHistogram()}, time.Second); err != nil {
+			return fmt.Errorf("SLA rule %d: %w", i+1, err)
+		}
+		slaRules = append(slaRules, r)
+	}
+	l.Infof("Loaded %d SLA rules from %s\n", len(slaRules), path)
+
+	return nil
+}
+
+// slaMetric returns a value of a metric from summary data collected during test of provided duration
+func slaMetric(metric string, s *summaryData, testDuration time.Duration) (float64, error) {
+	h := s.durations
+	switch metric {
+	case "count":
+		return float64(h.count), nil
+	case "koCount":
+		return float64(s.koCount), nil
+	case "errorRate":
+		if h.count == 0 {
+			return 0, nil
+		}
+		return float64(s.koCount) / float64(h.count) * 100, nil
+	case "rps":
+		if testDuration <= 0 {
+			return 0, nil
+		}
+		return float64(h.count) / testDuration.Seconds(), nil
+	case "mean":
+		return h.mean(), nil
+	case "min":
+		if h.count == 0 {
+			return 0, nil
+		}
+		return float64(h.min), nil
+	case "max":
+		return float64(h.max), nil
+	case "p50":
+		return float64(h.valueAt(50)), nil
+	case "p75":
+		return float64(h.valueAt(75)), nil
+	case "p90":
+		return float64(h.valueAt(90)), nil
+	case "p95":
+		return float64(h.valueAt(95)), nil
+	case "p99":
+		return float64(h.valueAt(99)), nil
+	case "p999":
+		return float64(h.valueAt(99.9)), nil
+	}
+
+	return 0, fmt.Errorf("unknown metric %q", metric)
+}
+
+// evaluateSLA checks all SLA rules against whole test summaries and sends results,
+// should be called only after requestsAggregator is stopped
+func evaluateSLA(timestamp time.Time) {
+	if len(slaRules) == 0 {
+		return
+	}
+	testDuration := lastPoint.Sub(info.testStartTime)
+
+	for _, r := range slaRules {
+		var matched int
+		check := func(name, groups string, s *summaryData) {
+			matched++
+			actual, _ := slaMetric(r.Metric, s, testDuration)
+			slaResults = append(slaResults, SLAResult{
+				Rule:      r.String(),
+				Scope:     r.Scope,
+				Name:      name,
+				Groups:    groups,
+				Metric:    r.Metric,
+				Actual:    actual,
+				Threshold: *r.Value,
+				Passed:    slaOps[r.Op](actual, *r.Value),
+			})
+		}
+
+		if r.Scope == slaScopeGlobal {
+			check("", "", totalSummary)
+		}
+		for k, s := range summaries {
+			if k.isGroup != (r.Scope == slaScopeGroup) || r.Scope == slaScopeGlobal {
+				continue
+			}
+			if r.Name != "" && r.Name != k.name {
+				continue
+			}
+			check(k.name, k.groups, s)
+		}
+
+		// Rule for a request or group that was never executed can not be satisfied
+		if matched == 0 {
+			slaResults = append(slaResults, SLAResult{
+				Rule:      r.String(),
+				Scope:     r.Scope,
+				Name:      r.Name,
+				Metric:    r.Metric,
+				Threshold: *r.Value,
+			})
+		}
+	}
+
+	for _, res := range slaResults {
+		if res.Passed {
+			l.Infof("SLA passed: %s, actual value %v\n", res.Rule, res.Actual)
+		} else {
+			l.Errorf("SLA failed: %s, actual value %v for %s\n", res.Rule, res.Actual, strings.TrimSpace(res.Groups+" "+res.Name))
+		}
+
+		point, err := NewPoint(
+			"sla",
+			map[string]string{
+				"rule":       res.Rule,
+				"scope":      res.Scope,
+				"name":       res.Name,
+				"groups":     res.Groups,
+				"metric":     res.Metric,
+				"simulation": info.simulationName,
+				"testId":     info.testID,
+				"nodeName":   info.nodeName,
+			},
+			map[string]interface{}{
+				"actual":    res.Actual,
+				"threshold": res.Threshold,
+				"passed":    res.Passed,
+			},
+			timestamp,
+		)
+		if err != nil {
+			l.Errorf("Error creating new point with SLA data: %v\n", err)
+			continue
+		}
+
+		pc <- point
+	}
+}
+
+// SLAResults returns results of SLA checks performed at the end of the test
+func SLAResults() []SLAResult {
+	return slaResults
+}
+
+// CheckSLA returns an error if any SLA rule failed
+func CheckSLA() error {
+	var failed int
+	for _, res := range slaResults {
+		if !res.Passed {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d SLA checks failed", failed, len(slaResults))
+	}
+	if len(slaRules) > 0 && len(slaResults) == 0 {
+		return errors.New("SLA checks were not performed, test did not start")
+	}
+
+	return nil
+}
@@ -0,0 +1,173 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package influx
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loadTestSLA loads SLA rules from provided YAML document
+func loadTestSLA(t *testing.T, doc string) error {
+	dir, err := ioutil.TempDir("", "g2i-sla")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sla.yaml")
+	if err := ioutil.WriteFile(path, []byte(doc), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	slaRules = nil
+	return InitSLA(path)
+}
+
+// resetSLA clears rules, results and test data used by SLA checks
+func resetSLA() {
+	slaRules = nil
+	slaResults = nil
+	summaries = make(map[summaryKey]*summaryData)
+	totalSummary = &summaryData{durations: newHistogram()}
+	info = testInfo{}
+	lastPoint = time.Time{}
+}
+
+func TestInitSLAValidation(t *testing.T) {
+	defer resetSLA()
+
+	for name, doc := range map[string]string{
+		"missing value":    "rules:\n  - metric: p95\n    op: '<'\n",
+		"misspelled value": "rules:\n  - metric: p95\n    op: '<'\n    threshold: 800\n",
+		"unknown key":      "rules:\n  - metric: p95\n    op: '<'\n    value: 800\n    scenario: search\n",
+		"unknown scope":    "rules:\n  - scope: user\n    metric: p95\n    op: '<'\n    value: 800\n",
+		"unknown metric":   "rules:\n  - metric: p42\n    op: '<'\n    value: 800\n",
+		"unknown operator": "rules:\n  - metric: p95\n    op: '=='\n    value: 800\n",
+		"no rules":         "rules: []\n",
+	} {
+		if err := loadTestSLA(t, doc); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	if err := loadTestSLA(t, "rules:\n  - metric: errorRate\n    op: '<'\n    value: 0\n"); err != nil {
+		t.Fatalf("zero value must be accepted: %v", err)
+	}
+	if len(slaRules) != 1 || slaRules[0].Scope != slaScopeGlobal || *slaRules[0].Value != 0 {
+		t.Errorf("unexpected rules %+v", slaRules)
+	}
+}
+
+func TestEvaluateSLA(t *testing.T) {
+	defer resetSLA()
+
+	err := loadTestSLA(t, `
+rules:
+  - metric: errorRate
+    op: "<"
+    value: 10
+  - scope: request
+    metric: max
+    op: "<="
+    value: 500
+  - scope: group
+    name: checkout
+    metric: count
+    op: ">="
+    value: 1
+  - scope: request
+    name: never executed
+    metric: count
+    op: ">="
+    value: 0
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info.testStartTime = testTime
+	lastPoint = testTime.Add(10 * time.Second)
+	for _, r := range []requestLineData{
+		{name: "home", result: "OK", duration: 100},
+		{name: "home", result: "OK", duration: 300},
+		{name: "search", result: "KO", duration: 700},
+		{name: "checkout", result: "OK", duration: 1000, isGroup: true},
+	} {
+		addToSummary(r)
+	}
+
+	evaluateSLA(lastPoint)
+
+	results := make(map[string]SLAResult)
+	for _, res := range slaResults {
+		results[res.Rule+" "+res.Name] = res
+	}
+	for key, passed := range map[string]bool{
+		"global errorRate < 10 ":                             false,
+		`request "*" max <= 500 home`:                        true,
+		`request "*" max <= 500 search`:                      false,
+		`group "checkout" count >= 1 checkout`:               true,
+		`request "never executed" count >= 0 never executed`: false,
+	} {
+		res, ok := results[key]
+		if !ok {
+			t.Errorf("no result for %q in %v", key, results)
+			continue
+		}
+		if res.Passed != passed {
+			t.Errorf("%q: passed = %v, want %v (actual %v)", key, res.Passed, passed, res.Actual)
+		}
+	}
+	if len(slaResults) != 5 {
+		t.Errorf("expected 5 results, got %d", len(slaResults))
+	}
+
+	err = CheckSLA()
+	if err == nil || !strings.HasPrefix(err.Error(), "3 of 5") {
+		t.Errorf("unexpected CheckSLA error: %v", err)
+	}
+}
+
+func TestCheckSLA(t *testing.T) {
+	defer resetSLA()
+
+	if err := CheckSLA(); err != nil {
+		t.Errorf("no rules: unexpected error %v", err)
+	}
+
+	if err := loadTestSLA(t, "rules:\n  - metric: count\n    op: '>'\n    value: 0\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckSLA(); err == nil {
+		t.Error("rules that were never evaluated must fail")
+	}
+
+	slaResults = []SLAResult{{Rule: "global count > 0", Passed: true}}
+	if err := CheckSLA(); err != nil {
+		t.Errorf("passed rules: unexpected error %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package influx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/influxdata/influxdb1-client/models"
+	infc "github.com/influxdata/influxdb1-client/v2"
+)
+
+const spoolFileExt = ".lp"
+
+var errSpoolFull = errors.New("Spool size limit is reached")
+
+// spool is an on-disk queue of batches that could not be delivered to a sink.
+// Each batch is stored as a separate line protocol file named by its sequence number,
+// so batches are replayed in the same order they were spooled, even after restart
+type spool struct {
+	dir     string
+	maxSize int64
+	// files are names of spooled batches in order of their creation
+	files []string
+	size  int64
+	next  uint64
+}
+
+// openSpool opens a spool directory picking up batches left by previous runs
+func openSpool(dir string, maxSize int64) (*spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("Failed to create spool directory: %w", err)
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read spool directory: %w", err)
+	}
+
+	s := &spool{dir: dir, maxSize: maxSize}
+	for _, e := range entries {
+		if !e.Mode().IsRegular() || !strings.HasSuffix(e.Name(), spoolFileExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(e.Name(), spoolFileExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		s.files = append(s.files, e.Name())
+		s.size += e.Size()
+		if seq >= s.next {
+			s.next = seq + 1
+		}
+	}
+	// Names are zero padded, so lexical order is the order of creation
+	sort.Strings(s.files)
+
+	return s, nil
+}
+
+func (s *spool) len() int {
+	return len(s.files)
+}
+
+// push stores a batch as the newest spool file
+func (s *spool) push(points []*infc.Point) error {
+	var b bytes.Buffer
+	for _, p := range points {
+		b.WriteString(p.PrecisionString("ns"))
+		b.WriteByte('\n')
+	}
+	if s.maxSize > 0 && s.size+int64(b.Len()) > s.maxSize {
+		return errSpoolFull
+	}
+
+	name := fmt.Sprintf("%020d%s", s.next, spoolFileExt)
+	// Writing to a temporary file first, so a crash never leaves a partial batch
+	tmp := filepath.Join(s.dir, name+".tmp")
+	if err := ioutil.WriteFile(tmp, b.Bytes(), 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, name)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	s.next++
+	s.files = append(s.files, name)
+	s.size += int64(b.Len())
+
+	return nil
+}
+
+// peek reads the oldest spooled batch
+func (s *spool) peek() ([]*infc.Point, error) {
+	b, err := ioutil.ReadFile(filepath.Join(s.dir, s.files[0]))
+	if err != nil {
+		return nil, err
+	}
+	mps, err := models.ParsePoints(b)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse spooled batch %s: %w", s.files[0], err)
+	}
+	points := make([]*infc.Point, 0, len(mps))
+	for _, mp := range mps {
+		points = append(points, infc.NewPointFrom(mp))
+	}
+
+	return points, nil
+}
+
+// pop removes the oldest spooled batch
+func (s *spool) pop() error {
+	path := filepath.Join(s.dir, s.files[0])
+	fInfo, err := os.Stat(path)
+	if err == nil {
+		s.size -= fInfo.Size()
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	s.files = s.files[1:]
+
+	return nil
+}
@@ -0,0 +1,108 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package influx
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	l "github.com/dakaraj/gatling-to-influxdb/logger"
+)
+
+// extraTags are static tags added to every point, e.g. environment or build number
+var extraTags map[string]string
+
+// splitTag splits key=value tag definition
+func splitTag(def string) (string, string, error) {
+	parts := strings.SplitN(def, "=", 2)
+	key := strings.TrimSpace(parts[0])
+	if len(parts) != 2 || key == "" {
+		return "", "", fmt.Errorf("Invalid tag %q, expected key=value", def)
+	}
+
+	return key, strings.TrimSpace(parts[1]), nil
+}
+
+// initTags sets static tags from key=value definitions and from environment variables.
+// Environment tag is defined either as key=VARIABLE or as VARIABLE which is used as a key
+func initTags(tags, envTags []string) error {
+	extraTags = make(map[string]string)
+	for _, def := range tags {
+		key, value, err := splitTag(def)
+		if err != nil {
+			return err
+		}
+		if value == "" {
+			return fmt.Errorf("Tag %s has empty value", key)
+		}
+		extraTags[key] = value
+	}
+
+	for _, def := range envTags {
+		key, variable := def, def
+		if strings.Contains(def, "=") {
+			var err error
+			if key, variable, err = splitTag(def); err != nil {
+				return err
+			}
+		}
+		// Missing CI variable should not break local runs, so tag is just skipped
+		value := os.Getenv(variable)
+		if value == "" {
+			l.Infof("Environment variable %s is not set, tag %s is skipped\n", variable, key)
+			continue
+		}
+		extraTags[key] = value
+	}
+
+	if len(extraTags) > 0 {
+		keys := make([]string, 0, len(extraTags))
+		for k := range extraTags {
+			keys = append(keys, k+"="+extraTags[k])
+		}
+		sort.Strings(keys)
+		l.Infof("Extra tags added to all points: %s\n", strings.Join(keys, ", "))
+	}
+
+	return nil
+}
+
+// addExtraTags returns tags of a point being created with static tags added. Tags set by
+// the point itself take precedence, so measurements keep their meaning. Provided map is
+// not modified, as callers may reuse it
+func addExtraTags(tags map[string]string) map[string]string {
+	if len(extraTags) == 0 {
+		return tags
+	}
+	merged := make(map[string]string, len(tags)+len(extraTags))
+	for k, v := range extraTags {
+		merged[k] = v
+	}
+	for k, v := range tags {
+		merged[k] = v
+	}
+
+	return merged
+}
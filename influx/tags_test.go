@@ -0,0 +1,69 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package influx
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewPointAddsExtraTags(t *testing.T) {
+	os.Setenv("G2I_TEST_BUILD", "42")
+	defer os.Unsetenv("G2I_TEST_BUILD")
+	if err := initTags([]string{"env=staging", "testId=ignored"}, []string{"build=G2I_TEST_BUILD", "G2I_TEST_MISSING"}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { extraTags = nil }()
+
+	tags := map[string]string{"testId": "t1"}
+	p, err := NewPoint("requests", tags, map[string]interface{}{"duration": 1}, time.Unix(0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"testId": "t1", "env": "staging", "build": "42"}
+	if got := p.Tags(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got tags %v, want %v", got, want)
+	}
+	if want := map[string]string{"testId": "t1"}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("tags of caller are modified: %v", tags)
+	}
+
+	p, err = NewPoint("tests", nil, map[string]interface{}{"description": ""}, time.Unix(0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := p.Tags(); len(got) != 3 {
+		t.Errorf("expected extra tags on point without tags, got %v", got)
+	}
+}
+
+func TestInitTagsErrors(t *testing.T) {
+	defer func() { extraTags = nil }()
+	for _, tags := range [][]string{{"novalue"}, {"=value"}, {"key="}} {
+		if err := initTags(tags, nil); err == nil {
+			t.Errorf("expected error for %q", tags)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package influx
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	l "github.com/dakaraj/gatling-to-influxdb/logger"
+	infc "github.com/influxdata/influxdb1-client/v2"
+)
+
+// partialWriteError is returned by transports that do not retry failed writes,
+// when only a part of batch points was delivered
+type partialWriteError struct {
+	failed int
+	err    error
+}
+
+func (e *partialWriteError) Error() string {
+	return fmt.Sprintf("%d points were not delivered: %v", e.failed, e.err)
+}
+
+func (e *partialWriteError) Unwrap() error {
+	return e.err
+}
+
+// udpClient implements client interface writing line protocol to InfluxDB UDP listener.
+// Writes are fire-and-forget: points are packed into packets not exceeding payload size,
+// while packets that failed to be sent and points that do not fit any packet are dropped
+type udpClient struct {
+	conn        net.Conn
+	payloadSize int
+}
+
+func newUDPClient(address string, payloadSize int) (*udpClient, error) {
+	if payloadSize <= 0 {
+		payloadSize = infc.UDPPayloadSize
+	}
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to resolve UDP address %s: %w", address, err)
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open UDP connection to %s: %w", address, err)
+	}
+
+	return &udpClient{
+		conn:        conn,
+		payloadSize: payloadSize,
+	}, nil
+}
+
+// Write splits batch points into packets fitting payload size and sends them
+func (c *udpClient) Write(bp infc.BatchPoints) error {
+	var (
+		packet        = make([]byte, 0, c.payloadSize)
+		packetPoints  int
+		oversized     int
+		dropped       int
+		droppedPacket int
+		lastErr       error
+	)
+
+	flush := func() {
+		if len(packet) == 0 {
+			return
+		}
+		if _, err := c.conn.Write(packet); err != nil {
+			lastErr = err
+			dropped += packetPoints
+			droppedPacket++
+		}
+		packet = packet[:0]
+		packetPoints = 0
+	}
+
+	for _, p := range bp.Points() {
+		line := p.PrecisionString(bp.Precision())
+		// Include line break in size
+		size := len(line) + 1
+		if size > c.payloadSize {
+			oversized++
+			continue
+		}
+		if len(packet)+size > c.payloadSize {
+			flush()
+		}
+		packet = append(packet, line...)
+		packet = append(packet, '\n')
+		packetPoints++
+	}
+	flush()
+
+	if oversized > 0 {
+		l.Errorf("Dropped %d points exceeding UDP payload size of %d bytes\n", oversized, c.payloadSize)
+		if lastErr == nil {
+			lastErr = errors.New("points exceed UDP payload size")
+		}
+	}
+	if droppedPacket > 0 {
+		l.Errorf("Dropped %d UDP packets with %d points: %v\n", droppedPacket, dropped, lastErr)
+	}
+	if oversized+dropped > 0 {
+		return &partialWriteError{failed: oversized + dropped, err: lastErr}
+	}
+
+	return nil
+}
+
+// Ping does nothing as UDP has no way to check if server is available
+func (c *udpClient) Ping(timeout time.Duration) (time.Duration, string, error) {
+	return 0, "", nil
+}
+
+// Query is not supported by UDP client
+func (c *udpClient) Query(q infc.Query) (*infc.Response, error) {
+	return nil, errors.New("Querying via UDP is not supported")
+}
+
+// QueryAsChunk is not supported by UDP client
+func (c *udpClient) QueryAsChunk(q infc.Query) (*infc.ChunkedResponse, error) {
+	return nil, errors.New("Querying via UDP is not supported")
+}
+
+// Close closes UDP connection
+func (c *udpClient) Close() error {
+	return c.conn.Close()
+}
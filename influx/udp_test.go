@@ -0,0 +1,96 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package influx
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	infc "github.com/influxdata/influxdb1-client/v2"
+)
+
+func TestUDPWriteSplitsPackets(t *testing.T) {
+	ln, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	const payloadSize = 200
+	c, err := newUDPClient(ln.LocalAddr().String(), payloadSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	bp, _ := infc.NewBatchPoints(infc.BatchPointsConfig{Precision: "ns"})
+	points := testPoints(t, 10)
+	bp.AddPoints(points)
+	// Point that does not fit any packet is dropped, others are still sent
+	huge, err := infc.NewPoint("m", map[string]string{"t": strings.Repeat("x", payloadSize)}, map[string]interface{}{"i": 0}, testTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bp.AddPoint(huge)
+
+	err = c.Write(bp)
+	var pwe *partialWriteError
+	if !errors.As(err, &pwe) || pwe.failed != 1 {
+		t.Fatalf("expected partial write error for 1 point, got %v", err)
+	}
+
+	var lines []string
+	var packets int
+	buf := make([]byte, 65536)
+	ln.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for len(lines) < len(points) {
+		n, err := ln.Read(buf)
+		if err != nil {
+			t.Fatalf("received %d of %d points: %v", len(lines), len(points), err)
+		}
+		if n > payloadSize {
+			t.Errorf("packet of %d bytes exceeds payload size %d", n, payloadSize)
+		}
+		packets++
+		packet := string(buf[:n])
+		if !strings.HasSuffix(packet, "\n") {
+			t.Errorf("packet is cut in the middle of a line: %q", packet)
+		}
+		lines = append(lines, strings.Split(strings.TrimSuffix(packet, "\n"), "\n")...)
+	}
+
+	if packets < 2 {
+		t.Errorf("points fit into %d packet, expected them to be split", packets)
+	}
+	if len(lines) != len(points) {
+		t.Fatalf("received %d points, want %d", len(lines), len(points))
+	}
+	for i, p := range points {
+		if want := p.PrecisionString("ns"); lines[i] != want {
+			t.Errorf("line %d = %q, want %q", i, lines[i], want)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package influx
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	infc "github.com/influxdata/influxdb1-client/v2"
+)
+
+// v2Client implements client interface for InfluxDB 2.x and 3.x write API
+// authorized with a token. Only writing points is supported
+type v2Client struct {
+	httpClient *http.Client
+	address    string
+	org        string
+	bucket     string
+	token      string
+	userAgent  string
+}
+
+// v2Error is an error body returned by InfluxDB 2.x API
+type v2Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func newV2Client(address, org, bucket, token, userAgent string, timeout time.Duration) (*v2Client, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse InfluxDB address %s: %w", address, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("Unsupported protocol scheme %q, must be http or https", u.Scheme)
+	}
+	if bucket == "" {
+		return nil, errors.New("Bucket name is required for InfluxDB v2 API")
+	}
+
+	return &v2Client{
+		httpClient: &http.Client{Timeout: timeout},
+		address:    strings.TrimSuffix(address, "/"),
+		org:        org,
+		bucket:     bucket,
+		token:      token,
+		userAgent:  userAgent,
+	}, nil
+}
+
+func (c *v2Client) newRequest(method, path string, query url.Values, body io.Reader) (*http.Request, error) {
+	u := c.address + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", c.userAgent)
+	if c.token != "" {
+		req.Header.Set("Authorization", "Token "+c.token)
+	}
+
+	return req, nil
+}
+
+// do sends a request and returns response body if status code is successful
+func (c *v2Client) do(req *http.Request) (int, []byte, error) {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var e v2Error
+		if json.Unmarshal(body, &e) == nil && e.Message != "" {
+			return resp.StatusCode, body, fmt.Errorf("Server responded with %d: %s", resp.StatusCode, e.Message)
+		}
+		return resp.StatusCode, body, fmt.Errorf("Server responded with %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return resp.StatusCode, body, nil
+}
+
+// checkConnection pings the server and checks if configured organization and bucket exist
+// and are accessible with provided token. InfluxDB 3.x has no organizations and buckets API,
+// so these checks are skipped when endpoints are not found
+func (c *v2Client) checkConnection(timeout time.Duration) error {
+	if _, _, err := c.Ping(timeout); err != nil {
+		return err
+	}
+	if err := c.checkOrg(); err != nil {
+		return err
+	}
+
+	query := url.Values{"name": {c.bucket}}
+	if c.org != "" {
+		query.Set("org", c.org)
+	}
+	req, err := c.newRequest(http.MethodGet, "/api/v2/buckets", query, nil)
+	if err != nil {
+		return err
+	}
+	code, body, err := c.do(req)
+	if code == http.StatusNotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to check bucket %s: %w", c.bucket, err)
+	}
+
+	var buckets struct {
+		Buckets []struct {
+			Name string `json:"name"`
+		} `json:"buckets"`
+	}
+	if err := json.Unmarshal(body, &buckets); err != nil {
+		return fmt.Errorf("Failed to parse buckets response: %w", err)
+	}
+	for _, b := range buckets.Buckets {
+		if b.Name == c.bucket {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Bucket %s is not found or not accessible with provided token", c.bucket)
+}
+
+// checkOrg checks that organization is provided and accessible with provided token,
+// as InfluxDB 2.x rejects all writes otherwise
+func (c *v2Client) checkOrg() error {
+	var query url.Values
+	if c.org != "" {
+		query = url.Values{"org": {c.org}}
+	}
+	req, err := c.newRequest(http.MethodGet, "/api/v2/orgs", query, nil)
+	if err != nil {
+		return err
+	}
+	code, body, err := c.do(req)
+	var e v2Error
+	switch {
+	// InfluxDB 2.x responds with not found error to unknown organization
+	case code == http.StatusNotFound && json.Unmarshal(body, &e) == nil && e.Code == "not found":
+		return fmt.Errorf("Organization %s is not found or not accessible with provided token", c.org)
+	// InfluxDB 3.x has no organizations
+	case code == http.StatusNotFound:
+		return nil
+	case err != nil:
+		return fmt.Errorf("Failed to check organization %s: %w", c.org, err)
+	case c.org == "":
+		return errors.New("Organization is required for InfluxDB 2.x, provide it with --org key")
+	}
+
+	var orgs struct {
+		Orgs []struct {
+			Name string `json:"name"`
+		} `json:"orgs"`
+	}
+	if err := json.Unmarshal(body, &orgs); err != nil {
+		return fmt.Errorf("Failed to parse organizations response: %w", err)
+	}
+	for _, o := range orgs.Orgs {
+		if o.Name == c.org {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Organization %s is not found or not accessible with provided token", c.org)
+}
+
+// Ping checks that InfluxDB server is available
+func (c *v2Client) Ping(timeout time.Duration) (time.Duration, string, error) {
+	req, err := c.newRequest(http.MethodGet, "/ping", nil, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	start := time.Now()
+	hc := *c.httpClient
+	hc.Timeout = timeout
+	resp, err := hc.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return 0, "", fmt.Errorf("Ping responded with %d", resp.StatusCode)
+	}
+
+	return time.Since(start), resp.Header.Get("X-Influxdb-Version"), nil
+}
+
+// Write sends batch points to InfluxDB v2 write endpoint as line protocol
+func (c *v2Client) Write(bp infc.BatchPoints) error {
+	var b bytes.Buffer
+	for _, p := range bp.Points() {
+		b.WriteString(p.PrecisionString(bp.Precision()))
+		b.WriteByte('\n')
+	}
+
+	query := url.Values{
+		"bucket":    {c.bucket},
+		"precision": {bp.Precision()},
+	}
+	if c.org != "" {
+		query.Set("org", c.org)
+	}
+	req, err := c.newRequest(http.MethodPost, "/api/v2/write", query, &b)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	code, _, err := c.do(req)
+	if err != nil && isPermanentStatus(code) {
+		return &permanentError{err: err}
+	}
+
+	return err
+}
+
+// Query is not supported by v2 client
+func (c *v2Client) Query(q infc.Query) (*infc.Response, error) {
+	return nil, errors.New("Querying is not supported by InfluxDB v2 client")
+}
+
+// QueryAsChunk is not supported by v2 client
+func (c *v2Client) QueryAsChunk(q infc.Query) (*infc.ChunkedResponse, error) {
+	return nil, errors.New("Querying is not supported by InfluxDB v2 client")
+}
+
+// Close releases idle connections
+func (c *v2Client) Close() error {
+	c.httpClient.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,125 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package influx
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	infc "github.com/influxdata/influxdb1-client/v2"
+)
+
+// newFakeV2Server emulates InfluxDB 2.x API with a single organization and bucket,
+// or InfluxDB 3.x with no organizations and buckets API
+func newFakeV2Server(v3 bool) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/api/v2/write", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("bucket") == "conflict" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"code":"invalid","message":"field type conflict"}`)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	if v3 {
+		return httptest.NewServer(mux)
+	}
+	mux.HandleFunc("/api/v2/orgs", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("org") {
+		case "", "acme":
+			fmt.Fprint(w, `{"orgs":[{"name":"acme"}]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `{"code":"not found","message":"organization name \"%s\" not found"}`, r.URL.Query().Get("org"))
+		}
+	})
+	mux.HandleFunc("/api/v2/buckets", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("name") == "gatling" {
+			fmt.Fprint(w, `{"buckets":[{"name":"gatling"}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"buckets":[]}`)
+	})
+
+	return httptest.NewServer(mux)
+}
+
+func TestV2CheckConnection(t *testing.T) {
+	v2 := newFakeV2Server(false)
+	defer v2.Close()
+	v3 := newFakeV2Server(true)
+	defer v3.Close()
+
+	for _, tc := range []struct {
+		name    string
+		server  *httptest.Server
+		org     string
+		bucket  string
+		wantErr string
+	}{
+		{"valid", v2, "acme", "gatling", ""},
+		{"empty org", v2, "", "gatling", "Organization is required"},
+		{"unknown org", v2, "other", "gatling", "Organization other is not found"},
+		{"unknown bucket", v2, "acme", "other", "Bucket other is not found"},
+		{"v3 without org", v3, "", "gatling", ""},
+	} {
+		c, err := newV2Client(tc.server.URL, tc.org, tc.bucket, "token", "test", time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.checkConnection(time.Second)
+		switch {
+		case tc.wantErr == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tc.name, err)
+		case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+			t.Errorf("%s: expected error %q, got %v", tc.name, tc.wantErr, err)
+		}
+	}
+}
+
+func TestV2WriteRejectedIsPermanent(t *testing.T) {
+	s := newFakeV2Server(false)
+	defer s.Close()
+
+	bp, _ := infc.NewBatchPoints(infc.BatchPointsConfig{Precision: "ns"})
+	bp.AddPoints(testPoints(t, 1))
+	for bucket, permanent := range map[string]bool{"gatling": false, "conflict": true} {
+		c, err := newV2Client(s.URL, "acme", bucket, "token", "test", time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.Write(bp)
+		var pe *permanentError
+		if errors.As(err, &pe) != permanent {
+			t.Errorf("bucket %s: unexpected write result %v", bucket, err)
+		}
+	}
+}
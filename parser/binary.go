@@ -0,0 +1,484 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package parser
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf16"
+
+	"github.com/dakaraj/gatling-to-influxdb/influx"
+	l "github.com/dakaraj/gatling-to-influxdb/logger"
+)
+
+// Record headers of binary log format used by Gatling 3.10+
+const (
+	binaryRunHeader byte = iota
+	binaryRequestHeader
+	binaryUserHeader
+	binaryGroupHeader
+	binaryErrorHeader
+)
+
+// Java string coders used when serializing strings
+const (
+	latin1Coder byte = 0
+	utf16Coder  byte = 1
+)
+
+var (
+	errEmptyLog = errors.New("Log file is empty")
+	// maxBinaryStringLen protects from huge allocations on corrupted data
+	maxBinaryStringLen int32 = 64 << 20
+)
+
+// detectBinaryFormat checks the first byte of the log file to determine its format.
+// Text log always starts with RUN line while binary log starts with run record header
+func detectBinaryFormat(ctx context.Context, file *os.File) (bool, error) {
+	b := make([]byte, 1)
+	startWait := time.Now()
+	for {
+		drain := stopAtEOF()
+		n, err := file.ReadAt(b, 0)
+		if n == 1 {
+			return b[0] == binaryRunHeader, nil
+		}
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+		if drain || time.Now().After(startWait.Add(time.Duration(waitTime)*time.Second)) {
+			return false, errEmptyLog
+		}
+
+		select {
+		case <-ctx.Done():
+			return false, errStoppedByUser
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// tailReader reads a file that may still be written to. On the end of file it waits
+// for new data to appear until stop timeout is reached, unless log is already complete
+type tailReader struct {
+	ctx       context.Context
+	file      io.Reader
+	startWait time.Time
+}
+
+func (t *tailReader) Read(p []byte) (int, error) {
+	for {
+		drain := stopAtEOF()
+		n, err := t.file.Read(p)
+		if n > 0 {
+			t.startWait = time.Now()
+			return n, nil
+		}
+		if err != io.EOF {
+			return n, err
+		}
+		if drain {
+			return 0, io.EOF
+		}
+		// If no new data read for more than value provided by 'stop-timeout' key then processing is stopped
+		if time.Now().After(t.startWait.Add(time.Duration(waitTime) * time.Second)) {
+			l.Infof("No new records found for %d seconds. Stopping application...", waitTime)
+			return 0, io.EOF
+		}
+
+		select {
+		case <-t.ctx.Done():
+			return 0, errStoppedByUser
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// binaryDecoder decodes records of binary log format. Timestamps in records are stored
+// relative to the run start and repeated strings are replaced with dictionary references
+type binaryDecoder struct {
+	r         *bufio.Reader
+	runStart  int64
+	scenarios []string
+	strings   map[int32]string
+}
+
+func newBinaryDecoder(r io.Reader) *binaryDecoder {
+	return &binaryDecoder{
+		r:       bufio.NewReader(r),
+		strings: make(map[int32]string),
+	}
+}
+
+func (d *binaryDecoder) readByte() (byte, error) {
+	return d.r.ReadByte()
+}
+
+func (d *binaryDecoder) readBool() (bool, error) {
+	b, err := d.readByte()
+	return b != 0, err
+}
+
+func (d *binaryDecoder) readInt32() (int32, error) {
+	var v int32
+	err := binary.Read(d.r, binary.BigEndian, &v)
+	return v, err
+}
+
+func (d *binaryDecoder) readInt64() (int64, error) {
+	var v int64
+	err := binary.Read(d.r, binary.BigEndian, &v)
+	return v, err
+}
+
+// readTimestamp reads a timestamp relative to the run start and returns it as unix milliseconds
+func (d *binaryDecoder) readTimestamp() (int64, error) {
+	v, err := d.readInt32()
+	return d.runStart + int64(v), err
+}
+
+func (d *binaryDecoder) readByteArray() ([]byte, error) {
+	n, err := d.readInt32()
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 || n > maxBinaryStringLen {
+		return nil, fmt.Errorf("Invalid byte array length %d: %w", n, errFatal)
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(d.r, b)
+	return b, err
+}
+
+// readString reads a string serialized as its internal Java representation:
+// value bytes followed by a coder byte. Empty string is written as zero length only
+func (d *binaryDecoder) readString() (string, error) {
+	value, err := d.readByteArray()
+	if err != nil {
+		return "", err
+	}
+	if len(value) == 0 {
+		return "", nil
+	}
+	coder, err := d.readByte()
+	if err != nil {
+		return "", err
+	}
+
+	switch coder {
+	case latin1Coder:
+		runes := make([]rune, len(value))
+		for i, b := range value {
+			runes[i] = rune(b)
+		}
+		return string(runes), nil
+	case utf16Coder:
+		if len(value)%2 != 0 {
+			return "", fmt.Errorf("Invalid UTF-16 string length %d: %w", len(value), errFatal)
+		}
+		chars := make([]uint16, len(value)/2)
+		for i := range chars {
+			chars[i] = binary.LittleEndian.Uint16(value[i*2:])
+		}
+		return string(utf16.Decode(chars)), nil
+	default:
+		return "", fmt.Errorf("Unknown string coder %d: %w", coder, errFatal)
+	}
+}
+
+// readCachedString reads a string that is either defined in place with a new dictionary
+// index or referenced by a negative index of a previously defined string
+func (d *binaryDecoder) readCachedString() (string, error) {
+	index, err := d.readInt32()
+	if err != nil {
+		return "", err
+	}
+	if index < 0 {
+		s, ok := d.strings[-index]
+		if !ok {
+			return "", fmt.Errorf("Unknown string reference %d: %w", -index, errFatal)
+		}
+		return s, nil
+	}
+
+	s, err := d.readString()
+	if err != nil {
+		return "", err
+	}
+	d.strings[index] = s
+
+	return s, nil
+}
+
+// readGroups reads group hierarchy and returns it in the same form as text log does
+func (d *binaryDecoder) readGroups() (string, error) {
+	n, err := d.readInt32()
+	if err != nil {
+		return "", err
+	}
+	if n < 0 {
+		return "", fmt.Errorf("Invalid groups amount %d: %w", n, errFatal)
+	}
+	groups := make([]string, 0, n)
+	for i := int32(0); i < n; i++ {
+		g, err := d.readCachedString()
+		if err != nil {
+			return "", err
+		}
+		groups = append(groups, g)
+	}
+
+	return strings.Join(groups, ","), nil
+}
+
+func resultFromBool(ok bool) string {
+	if ok {
+		return "OK"
+	}
+	return "KO"
+}
+
+// This method should be called first when parsing started as all other records
+// depend on information from the run header
+func (d *binaryDecoder) runRecordProcess() error {
+	version, err := d.readString()
+	if err != nil {
+		return err
+	}
+	simulation, err := d.readString()
+	if err != nil {
+		return err
+	}
+	start, err := d.readInt64()
+	if err != nil {
+		return err
+	}
+	description, err := d.readString()
+	if err != nil {
+		return err
+	}
+
+	n, err := d.readInt32()
+	if err != nil {
+		return err
+	}
+	d.scenarios = make([]string, 0, n)
+	for i := int32(0); i < n; i++ {
+		s, err := d.readString()
+		if err != nil {
+			return err
+		}
+		d.scenarios = append(d.scenarios, s)
+	}
+
+	// Assertions are serialized definitions without results, which are only written
+	// to Gatling report, so they are only counted for JUnit report
+	n, err = d.readInt32()
+	if err != nil {
+		return err
+	}
+	for i := int32(0); i < n; i++ {
+		if _, err := d.readByteArray(); err != nil {
+			return err
+		}
+	}
+	influx.SetDeclaredAssertions(int(n))
+
+	d.runStart = start
+	warnNoUserIDs("Binary")
+
+	return sendRunPoint(simulation, strings.TrimSpace(description), version, start)
+}
+
+func (d *binaryDecoder) requestRecordProcess() error {
+	groups, err := d.readGroups()
+	if err != nil {
+		return err
+	}
+	name, err := d.readCachedString()
+	if err != nil {
+		return err
+	}
+	start, err := d.readTimestamp()
+	if err != nil {
+		return err
+	}
+	end, err := d.readTimestamp()
+	if err != nil {
+		return err
+	}
+	ok, err := d.readBool()
+	if err != nil {
+		return err
+	}
+	errorMessage, err := d.readCachedString()
+	if err != nil {
+		return err
+	}
+
+	// Binary log has no user IDs, so scenario is unknown for requests
+	return sendRequestPoint("", groups, name, resultFromBool(ok), errorMessage, start, end)
+}
+
+func (d *binaryDecoder) userRecordProcess() error {
+	scenarioIndex, err := d.readInt32()
+	if err != nil {
+		return err
+	}
+	start, err := d.readBool()
+	if err != nil {
+		return err
+	}
+	timestamp, err := d.readTimestamp()
+	if err != nil {
+		return err
+	}
+	if scenarioIndex < 0 || int(scenarioIndex) >= len(d.scenarios) {
+		return fmt.Errorf("Unknown scenario index %d", scenarioIndex)
+	}
+
+	status := "END"
+	if start {
+		status = "START"
+	}
+	influx.SendUserLineData(timeFromUnix(timestamp), d.scenarios[scenarioIndex], status)
+
+	return nil
+}
+
+func (d *binaryDecoder) groupRecordProcess() error {
+	groups, err := d.readGroups()
+	if err != nil {
+		return err
+	}
+	start, err := d.readTimestamp()
+	if err != nil {
+		return err
+	}
+	end, err := d.readTimestamp()
+	if err != nil {
+		return err
+	}
+	rawDuration, err := d.readInt32()
+	if err != nil {
+		return err
+	}
+	ok, err := d.readBool()
+	if err != nil {
+		return err
+	}
+
+	return sendGroupPoint("", groups, resultFromBool(ok), start, end, int64(rawDuration))
+}
+
+func (d *binaryDecoder) errorRecordProcess() error {
+	errorMessage, err := d.readCachedString()
+	if err != nil {
+		return err
+	}
+	timestamp, err := d.readTimestamp()
+	if err != nil {
+		return err
+	}
+
+	return sendErrorPoint(errorMessage, timestamp)
+}
+
+// recordProcess reads a single record from the binary log and processes it
+// based on its header
+func (d *binaryDecoder) recordProcess() error {
+	header, err := d.readByte()
+	if err != nil {
+		return err
+	}
+
+	switch header {
+	case binaryRunHeader:
+		err = d.runRecordProcess()
+		if err != nil && !errors.Is(err, errStoppedByUser) && err != io.EOF {
+			// Wrapping in a fatal error because further processing is futile
+			err = fmt.Errorf("%v: %w", err, errFatal)
+		}
+	case binaryRequestHeader:
+		err = d.requestRecordProcess()
+	case binaryUserHeader:
+		err = d.userRecordProcess()
+	case binaryGroupHeader:
+		err = d.groupRecordProcess()
+	case binaryErrorHeader:
+		err = d.errorRecordProcess()
+	default:
+		// There is no way to find the next record after an unknown one
+		return fmt.Errorf("Unknown record type %d encountered: %w", header, errFatal)
+	}
+	// Records have no separators, so a record cut in the middle breaks all further decoding
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+func binaryFileProcessor(ctx context.Context, file *os.File) {
+	d := newBinaryDecoder(&tailReader{ctx: ctx, file: file, startWait: time.Now()})
+ParseLoop:
+	for {
+		// This block checks if stop signal is received from user
+		// and stops further processing
+		select {
+		case <-ctx.Done():
+			l.Infoln("Parser received closing signal. Processing stopped")
+			break ParseLoop
+		default:
+		}
+
+		err := d.recordProcess()
+		switch {
+		case err == nil:
+			atomic.AddUint64(&linesParsed, 1)
+		case err == io.EOF:
+			l.Infoln("Reached the end of log file. Processing stopped")
+			break ParseLoop
+		case errors.Is(err, errStoppedByUser):
+			l.Infoln("Parser received closing signal. Processing stopped")
+			break ParseLoop
+		case err == io.ErrUnexpectedEOF, errors.Is(err, errFatal):
+			atomic.AddUint64(&linesFailed, 1)
+			l.Errorf("Record processing failed: %v", err)
+			l.Errorln("Log parser caught an error that can't be handled. Stopping application...")
+			break ParseLoop
+		default:
+			atomic.AddUint64(&linesFailed, 1)
+			l.Errorf("Record processing failed: %v", err)
+		}
+	}
+	parserStopped <- struct{}{}
+}
@@ -0,0 +1,221 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"unicode/utf16"
+)
+
+// binaryLog builds binary log fixtures the same way Gatling serializes them
+type binaryLog struct {
+	bytes.Buffer
+}
+
+func (b *binaryLog) int32(v int32) {
+	_ = binary.Write(b, binary.BigEndian, v)
+}
+
+func (b *binaryLog) int64(v int64) {
+	_ = binary.Write(b, binary.BigEndian, v)
+}
+
+func (b *binaryLog) bool(v bool) {
+	if v {
+		b.WriteByte(1)
+	} else {
+		b.WriteByte(0)
+	}
+}
+
+// string writes latin1 string; empty string has no coder byte
+func (b *binaryLog) string(s string) {
+	runes := []rune(s)
+	b.int32(int32(len(runes)))
+	if len(runes) == 0 {
+		return
+	}
+	for _, r := range runes {
+		b.WriteByte(byte(r))
+	}
+	b.WriteByte(latin1Coder)
+}
+
+func (b *binaryLog) utf16String(s string) {
+	units := utf16.Encode([]rune(s))
+	b.int32(int32(len(units) * 2))
+	for _, u := range units {
+		b.WriteByte(byte(u))
+		b.WriteByte(byte(u >> 8))
+	}
+	b.WriteByte(utf16Coder)
+}
+
+func (b *binaryLog) cachedString(index int32, s string) {
+	b.int32(index)
+	b.string(s)
+}
+
+func (b *binaryLog) runHeader(start int64, description string, scenarios ...string) {
+	b.WriteByte(binaryRunHeader)
+	b.string("3.10.3")
+	b.string("com.example.BinarySimulation")
+	b.int64(start)
+	b.string(description)
+	b.int32(int32(len(scenarios)))
+	for _, s := range scenarios {
+		b.string(s)
+	}
+	// no assertions
+	b.int32(0)
+}
+
+func TestBinaryDecoder(t *testing.T) {
+	var b binaryLog
+	b.runHeader(1596196277000, "", "Scenario A")
+
+	b.WriteByte(binaryUserHeader)
+	b.int32(0)
+	b.bool(true)
+	b.int32(10)
+
+	// request with empty OK message defined in cache
+	b.WriteByte(binaryRequestHeader)
+	b.int32(1)
+	b.cachedString(1, "group")
+	b.cachedString(2, "request")
+	b.int32(100)
+	b.int32(150)
+	b.bool(true)
+	b.cachedString(3, "")
+
+	// request referencing all previously cached strings
+	b.WriteByte(binaryRequestHeader)
+	b.int32(1)
+	b.int32(-1)
+	b.int32(-2)
+	b.int32(200)
+	b.int32(260)
+	b.bool(true)
+	b.int32(-3)
+
+	b.WriteByte(binaryRequestHeader)
+	b.int32(0)
+	b.int32(-2)
+	b.int32(300)
+	b.int32(310)
+	b.bool(false)
+	b.cachedString(4, "boom")
+
+	b.WriteByte(binaryGroupHeader)
+	b.int32(1)
+	b.int32(-1)
+	b.int32(100)
+	b.int32(270)
+	b.int32(110)
+	b.bool(true)
+
+	b.WriteByte(binaryErrorHeader)
+	b.int32(-4)
+	b.int32(400)
+
+	d := newBinaryDecoder(&b)
+	for i := 0; i < 7; i++ {
+		if err := d.recordProcess(); err != nil {
+			t.Fatalf("record %d: unexpected error: %v", i, err)
+		}
+	}
+	if err := d.recordProcess(); err != io.EOF {
+		t.Fatalf("expected EOF after last record, got %v", err)
+	}
+
+	if d.runStart != 1596196277000 {
+		t.Errorf("runStart = %d", d.runStart)
+	}
+	if len(d.scenarios) != 1 || d.scenarios[0] != "Scenario A" {
+		t.Errorf("scenarios = %q", d.scenarios)
+	}
+	want := map[int32]string{1: "group", 2: "request", 3: "", 4: "boom"}
+	if len(d.strings) != len(want) {
+		t.Errorf("cached strings = %q, want %q", d.strings, want)
+	}
+	for k, v := range want {
+		if s, ok := d.strings[k]; !ok || s != v {
+			t.Errorf("cached string %d = %q, want %q", k, s, v)
+		}
+	}
+}
+
+func TestBinaryDecoderStrings(t *testing.T) {
+	var b binaryLog
+	b.string("")
+	b.string("latin1 ÿ")
+	b.utf16String("utf16 ✓")
+	b.string("")
+
+	d := newBinaryDecoder(&b)
+	for _, want := range []string{"", "latin1 ÿ", "utf16 ✓", ""} {
+		s, err := d.readString()
+		if err != nil {
+			t.Fatalf("reading %q: %v", want, err)
+		}
+		if s != want {
+			t.Errorf("got %q, want %q", s, want)
+		}
+	}
+}
+
+func TestBinaryDecoderErrors(t *testing.T) {
+	var b binaryLog
+	b.runHeader(1596196277000, "desc", "Scenario A")
+	b.WriteByte(binaryRequestHeader)
+	b.int32(1)
+	b.int32(-5)
+
+	d := newBinaryDecoder(&b)
+	if err := d.recordProcess(); err != nil {
+		t.Fatalf("run header: %v", err)
+	}
+	if err := d.recordProcess(); !errors.Is(err, errFatal) {
+		t.Errorf("unknown string reference: expected fatal error, got %v", err)
+	}
+
+	b.Reset()
+	b.WriteByte(binaryUserHeader)
+	b.int32(0)
+	d = newBinaryDecoder(&b)
+	if err := d.recordProcess(); err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated record: expected unexpected EOF, got %v", err)
+	}
+
+	b.Reset()
+	b.WriteByte(42)
+	d = newBinaryDecoder(&b)
+	if err := d.recordProcess(); !errors.Is(err, errFatal) {
+		t.Errorf("unknown record: expected fatal error, got %v", err)
+	}
+}
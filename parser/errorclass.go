@@ -0,0 +1,134 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package parser
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/dakaraj/gatling-to-influxdb/config"
+	l "github.com/dakaraj/gatling-to-influxdb/logger"
+)
+
+// otherErrorClass is a class of error messages not matched by any rule
+const otherErrorClass = "other"
+
+// errorRule classifies error messages matching a regular expression. Class and status
+// code may refer to submatches like $1
+type errorRule struct {
+	Match      string `json:"match"`
+	Class      string `json:"class"`
+	StatusCode string `json:"statusCode"`
+	re         *regexp.Regexp
+}
+
+// builtinErrorRules classify common Gatling check failures and connection errors.
+// Check failures start with the check name, so they go first to avoid matching
+// their expressions by connection error rules
+var builtinErrorRules = mustCompileErrorRules([]errorRule{
+	{Match: `^status\b.*found (\d{3})`, Class: "status", StatusCode: "$1"},
+	{Match: `^regex\(`, Class: "regexCheck"},
+	{Match: `^(jsonPath|jmesPath|jsonpJsonPath|jsonpJmesPath)\(`, Class: "jsonPathCheck"},
+	{Match: `^css\(`, Class: "cssCheck"},
+	{Match: `^xpath\(`, Class: "xpathCheck"},
+	{Match: `^header(Regex)?\(`, Class: "headerCheck"},
+	{Match: `^(bodyString|bodyBytes|bodyLength|substring|md5|sha1)\b`, Class: "bodyCheck"},
+	{Match: `(?i)timeout|timed out`, Class: "timeout"},
+	{Match: `(?i)connection refused`, Class: "connectionRefused"},
+	{Match: `(?i)connection reset|prematurely closed|PrematureClose|connection closed|broken pipe`, Class: "connectionClosed"},
+	{Match: `(?i)\b(ssl|tls)(v[\d.]+)?\b|\bssl\w*exception|certificate|handshake`, Class: "ssl"},
+	{Match: `(?i)UnknownHost|no such host|name or service not known|nodename nor servname`, Class: "dns"},
+	{Match: `\.find\b|\.exists\b|\.notExists\b|\.is\(|\.in\(`, Class: "check"},
+})
+
+// errorRules are user defined rules which are checked before built-in ones
+var errorRules []errorRule
+
+func compileErrorRules(rules []errorRule) ([]errorRule, error) {
+	for i := range rules {
+		re, err := regexp.Compile(rules[i].Match)
+		if err != nil {
+			return nil, fmt.Errorf("Error rule %d: %w", i+1, err)
+		}
+		if rules[i].Class == "" {
+			return nil, fmt.Errorf("Error rule %d: class is not provided", i+1)
+		}
+		rules[i].re = re
+	}
+
+	return rules, nil
+}
+
+func mustCompileErrorRules(rules []errorRule) []errorRule {
+	compiled, err := compileErrorRules(rules)
+	if err != nil {
+		panic(err)
+	}
+
+	return compiled
+}
+
+// InitErrorRules loads error classification rules from a JSON, YAML or TOML file.
+// Empty path means that only built-in rules are used
+func InitErrorRules(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	var file struct {
+		Rules []errorRule `json:"rules"`
+	}
+	if err := config.DecodeFile(path, &file); err != nil {
+		return fmt.Errorf("Failed to load error rules: %w", err)
+	}
+	rules, err := compileErrorRules(file.Rules)
+	if err != nil {
+		return err
+	}
+	errorRules = rules
+	l.Infof("Loaded %d error rules from %s\n", len(errorRules), path)
+
+	return nil
+}
+
+// classifyError returns a class of error message and a status code found in it, if any.
+// Empty message has no class
+func classifyError(message string) (string, string) {
+	if message == "" {
+		return "", ""
+	}
+
+	for _, rules := range [][]errorRule{errorRules, builtinErrorRules} {
+		for _, r := range rules {
+			match := r.re.FindStringSubmatchIndex(message)
+			if match == nil {
+				continue
+			}
+			class := string(r.re.ExpandString(nil, r.Class, message, match))
+			statusCode := string(r.re.ExpandString(nil, r.StatusCode, message, match))
+			return class, statusCode
+		}
+	}
+
+	return otherErrorClass, ""
+}
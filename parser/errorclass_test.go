@@ -0,0 +1,50 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package parser
+
+import "testing"
+
+func TestClassifyError(t *testing.T) {
+	for message, want := range map[string][2]string{
+		"": {"", ""},
+		"status.find.in(200,201,202,203,204,205,206,207,208,209,304), but actually found 503":           {"status", "503"},
+		"regex(token=(\\w+)).find.exists, found nothing":                                                {"regexCheck", ""},
+		"jsonPath($.id).find.exists, found nothing":                                                     {"jsonPathCheck", ""},
+		"i.g.h.c.i.RequestTimeoutException: Request timeout to localhost/127.0.0.1:8080 after 60000 ms": {"timeout", ""},
+		"i.n.c.AbstractChannel$AnnotatedConnectException: Connection refused: localhost/127.0.0.1:8080": {"connectionRefused", ""},
+		"j.i.IOException: Connection reset by peer":                                                     {"connectionClosed", ""},
+		"javax.net.ssl.SSLHandshakeException: PKIX path building failed":                                {"ssl", ""},
+		"i.n.h.s.SslHandshakeTimeoutException: handshake timed out after 10000ms":                       {"timeout", ""},
+		"javax.net.ssl.SSLException: Received fatal alert: protocol_version":                            {"ssl", ""},
+		"Unsupported protocol TLSv1.1":                                                                  {"ssl", ""},
+		"j.n.UnknownHostException: nosuchhost.example":                                                  {"dns", ""},
+		"java.lang.ClassLoader failed to load feeder class":                                             {"other", ""},
+		"What a hassle, unexpected body":                                                                {"other", ""},
+		"Outlast the storm":                                                                             {"other", ""},
+	} {
+		class, statusCode := classifyError(message)
+		if class != want[0] || statusCode != want[1] {
+			t.Errorf("classifyError(%q) = %q, %q, want %q, %q", message, class, statusCode, want[0], want[1])
+		}
+	}
+}
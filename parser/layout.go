@@ -0,0 +1,135 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// requestLayout contains positions of values in REQUEST lines.
+// Negative position means that value is not present in a line
+type requestLayout struct {
+	length   int
+	scenario int
+	userID   int
+	groups   int
+	name     int
+	start    int
+	end      int
+	status   int
+	message  int
+}
+
+// groupLayout contains positions of values in GROUP lines
+type groupLayout struct {
+	length      int
+	scenario    int
+	userID      int
+	groups      int
+	start       int
+	end         int
+	rawDuration int
+	status      int
+}
+
+// userLayout contains positions of values in USER lines
+type userLayout struct {
+	length   int
+	scenario int
+	userID   int
+	status   int
+	start    int
+	end      int
+}
+
+// errorLayout contains positions of values in ERROR lines
+type errorLayout struct {
+	length    int
+	message   int
+	timestamp int
+}
+
+// lineLayout describes a text log format written by a particular range of Gatling versions
+type lineLayout struct {
+	name    string
+	request requestLayout
+	group   groupLayout
+	user    userLayout
+	error   errorLayout
+}
+
+// Layouts of text log format. Gatling 2.x puts record type after scenario and user ID,
+// Gatling 3.0-3.3 puts it first and Gatling 3.4+ removed scenario and user ID from
+// REQUEST and GROUP lines
+var (
+	gatling2Layout = &lineLayout{
+		name:    "2.x",
+		request: requestLayout{length: 9, scenario: 0, userID: 1, groups: 3, name: 4, start: 5, end: 6, status: 7, message: 8},
+		group:   groupLayout{length: 8, scenario: 0, userID: 1, groups: 3, start: 4, end: 5, rawDuration: 6, status: 7},
+		user:    userLayout{length: 6, scenario: 0, userID: 1, status: 3, start: 4, end: 5},
+		error:   errorLayout{length: 3, message: 1, timestamp: 2},
+	}
+	gatling30Layout = &lineLayout{
+		name:    "3.0-3.3",
+		request: requestLayout{length: 9, scenario: 1, userID: 2, groups: 3, name: 4, start: 5, end: 6, status: 7, message: 8},
+		group:   groupLayout{length: 8, scenario: 1, userID: 2, groups: 3, start: 4, end: 5, rawDuration: 6, status: 7},
+		user:    userLayout{length: 6, scenario: 1, userID: 2, status: 3, start: 4, end: 5},
+		error:   errorLayout{length: 3, message: 1, timestamp: 2},
+	}
+	gatling34Layout = &lineLayout{
+		name:    "3.4+",
+		request: requestLayout{length: 7, scenario: -1, userID: -1, groups: 1, name: 2, start: 3, end: 4, status: 5, message: 6},
+		group:   groupLayout{length: 6, scenario: -1, userID: -1, groups: 1, start: 2, end: 3, rawDuration: 4, status: 5},
+		user:    userLayout{length: 4, scenario: 1, userID: -1, status: 2, start: 3, end: 3},
+		error:   errorLayout{length: 3, message: 1, timestamp: 2},
+	}
+)
+
+// layoutForVersion picks a line layout matching provided Gatling version
+func layoutForVersion(version string) (*lineLayout, error) {
+	parts := strings.SplitN(strings.TrimSpace(version), ".", 3)
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return gatling34Layout, fmt.Errorf("Failed to parse Gatling version %q: %w", version, err)
+	}
+	minor := 0
+	if len(parts) > 1 {
+		minor, err = strconv.Atoi(parts[1])
+		if err != nil {
+			return gatling34Layout, fmt.Errorf("Failed to parse Gatling version %q: %w", version, err)
+		}
+	}
+
+	switch {
+	case major < 2:
+		return gatling34Layout, fmt.Errorf("Gatling version %s is not supported", version)
+	case major == 2:
+		return gatling2Layout, nil
+	case major == 3 && minor < 4:
+		return gatling30Layout, nil
+	default:
+		return gatling34Layout, nil
+	}
+}
@@ -0,0 +1,129 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package parser
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLayoutForVersion(t *testing.T) {
+	for _, tc := range []struct {
+		version string
+		want    *lineLayout
+		wantErr bool
+	}{
+		{"2.3.1", gatling2Layout, false},
+		{"2", gatling2Layout, false},
+		{"3.0.0-RC4", gatling30Layout, false},
+		{"3.3.1", gatling30Layout, false},
+		{" 3.3.1\n", gatling30Layout, false},
+		{"3.4.0", gatling34Layout, false},
+		{"3.10.3", gatling34Layout, false},
+		{"4.0", gatling34Layout, false},
+		{"1.5.6", gatling34Layout, true},
+		{"", gatling34Layout, true},
+		{"3.x", gatling34Layout, true},
+		{"unknown", gatling34Layout, true},
+	} {
+		got, err := layoutForVersion(tc.version)
+		if got != tc.want {
+			t.Errorf("layoutForVersion(%q) = %s, want %s", tc.version, got.name, tc.want.name)
+		}
+		if (err != nil) != tc.wantErr {
+			t.Errorf("layoutForVersion(%q) error = %v, want error %v", tc.version, err, tc.wantErr)
+		}
+	}
+}
+
+func TestLineLayouts(t *testing.T) {
+	defer resetSequences()
+
+	for _, tc := range []struct {
+		layout *lineLayout
+		lines  []string
+	}{
+		{gatling2Layout, []string{
+			"Search\t1\tUSER\tSTART\t1596196277000\t1596196277000",
+			"Search\t1\tREQUEST\tfind\tsearch\t1596196277200\t1596196277260\tKO\tstatus.find.is(200), but actually found 500",
+			"Search\t1\tGROUP\tfind\t1596196277200\t1596196277300\t60\tKO",
+		}},
+		{gatling30Layout, []string{
+			"USER\tSearch\t1\tSTART\t1596196277000\t1596196277000",
+			"REQUEST\tSearch\t1\tfind\tsearch\t1596196277200\t1596196277260\tKO\tstatus.find.is(200), but actually found 500",
+			"GROUP\tSearch\t1\tfind\t1596196277200\t1596196277300\t60\tKO",
+		}},
+		{gatling34Layout, []string{
+			"USER\tSearch\tSTART\t1596196277000",
+			"REQUEST\tfind\tsearch\t1596196277200\t1596196277260\tKO\tstatus.find.is(200), but actually found 500",
+			"GROUP\tfind\t1596196277200\t1596196277300\t60\tKO",
+		}},
+	} {
+		points, restore := capturePoints()
+		resetSequences()
+		processLines(t, tc.layout, tc.lines...)
+		restore()
+
+		scenario := "Search"
+		if tc.layout.request.userID < 0 {
+			scenario = ""
+		}
+		requests := pointTags(*points, "requests")
+		if len(requests) != 1 {
+			t.Fatalf("%s: expected a single request point, got %d", tc.layout.name, len(requests))
+		}
+		for k, want := range map[string]string{"scenario": scenario, "groups": "find", "name": "search", "result": "KO", "statusCode": "500"} {
+			if got := requests[0][k]; got != want {
+				t.Errorf("%s: request %s = %q, want %q", tc.layout.name, k, got, want)
+			}
+		}
+		groups := pointTags(*points, "groups")
+		if len(groups) != 1 {
+			t.Fatalf("%s: expected a single group point, got %d", tc.layout.name, len(groups))
+		}
+		for k, want := range map[string]string{"scenario": scenario, "name": "find", "result": "KO"} {
+			if got := groups[0][k]; got != want {
+				t.Errorf("%s: group %s = %q, want %q", tc.layout.name, k, got, want)
+			}
+		}
+
+		var fields []map[string]interface{}
+		for _, p := range *points {
+			f, _ := p.Fields()
+			fields = append(fields, f)
+		}
+		if fields[0]["duration"] != int64(60) || fields[1]["totalDuration"] != int64(100) || fields[1]["rawDuration"] != int64(60) {
+			t.Errorf("%s: unexpected fields %v", tc.layout.name, fields)
+		}
+	}
+}
+
+func TestLineOfOtherLayout(t *testing.T) {
+	defer func() { layout = gatling34Layout }()
+
+	layout = gatling30Layout
+	line := bytes.Split([]byte("REQUEST\tfind\tsearch\t1596196277200\t1596196277260\tOK\t "), tabSep)
+	if err := requestLineProcess(line); err == nil {
+		t.Error("expected error for line of Gatling 3.4+ parsed with layout of Gatling 3.0-3.3")
+	}
+}
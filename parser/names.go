@@ -0,0 +1,113 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package parser
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/dakaraj/gatling-to-influxdb/config"
+	l "github.com/dakaraj/gatling-to-influxdb/logger"
+)
+
+// otherName replaces request and group names beyond the cardinality limit
+const otherName = "other"
+
+// nameRule rewrites parts of request and group names matching a regular expression,
+// replacement may refer to submatches like $1
+type nameRule struct {
+	Match   string `json:"match"`
+	Replace string `json:"replace"`
+	re      *regexp.Regexp
+}
+
+// nameSet keeps distinct names seen in log to limit their amount
+type nameSet struct {
+	kind   string
+	names  map[string]struct{}
+	folded uint64
+}
+
+var (
+	nameRules []nameRule
+	// maxNames is a limit of distinct names of each kind, zero means no limit
+	maxNames int
+
+	requestNames = &nameSet{kind: "request", names: make(map[string]struct{})}
+	groupNames   = &nameSet{kind: "group", names: make(map[string]struct{})}
+)
+
+// InitNameRules loads name rewrite rules from a JSON, YAML or TOML file and sets a limit
+// of distinct request and group names. Empty path means no rewrite rules
+func InitNameRules(path string, limit uint) error {
+	maxNames = int(limit)
+	if path == "" {
+		return nil
+	}
+
+	var file struct {
+		Rules []nameRule `json:"rules"`
+	}
+	if err := config.DecodeFile(path, &file); err != nil {
+		return fmt.Errorf("Failed to load name rules: %w", err)
+	}
+	for i, r := range file.Rules {
+		re, err := regexp.Compile(r.Match)
+		if err != nil {
+			return fmt.Errorf("Name rule %d: %w", i+1, err)
+		}
+		r.re = re
+		nameRules = append(nameRules, r)
+	}
+	l.Infof("Loaded %d name rules from %s\n", len(nameRules), path)
+
+	return nil
+}
+
+// normalize rewrites a name by all rules in order and folds it into "other"
+// if the limit of distinct names is reached
+func (s *nameSet) normalize(name string) string {
+	for _, r := range nameRules {
+		name = r.re.ReplaceAllString(name, r.Replace)
+	}
+	if maxNames == 0 {
+		return name
+	}
+
+	if _, ok := s.names[name]; ok {
+		return name
+	}
+	if len(s.names) < maxNames {
+		s.names[name] = struct{}{}
+		return name
+	}
+
+	// Warn on the first folded name and then on every thousand, so log is not flooded
+	s.folded++
+	if s.folded%1000 == 1 {
+		l.Errorf("Limit of %d distinct %s names is reached, %q is written as %q (%d names folded so far). Consider adding name rules\n",
+			maxNames, s.kind, name, otherName, s.folded)
+	}
+
+	return otherName
+}
@@ -0,0 +1,129 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package parser
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// loadTestNameRules loads name rules from provided YAML document with provided limit
+func loadTestNameRules(t *testing.T, doc string, limit uint) error {
+	dir, err := ioutil.TempDir("", "g2i-names")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "names.yaml")
+	if err := ioutil.WriteFile(path, []byte(doc), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	resetNames()
+	return InitNameRules(path, limit)
+}
+
+func resetNames() {
+	nameRules = nil
+	maxNames = 0
+	requestNames = &nameSet{kind: "request", names: make(map[string]struct{})}
+	groupNames = &nameSet{kind: "group", names: make(map[string]struct{})}
+}
+
+func TestNameRules(t *testing.T) {
+	defer resetNames()
+
+	// Rules are applied in order, so the second one sees the result of the first one,
+	// but not of the third one
+	err := loadTestNameRules(t, `
+rules:
+  - match: '/orders/\d+'
+    replace: '/orders/:id'
+  - match: '^(GET|POST) (/\w+)/:id$'
+    replace: '$1 $2 item'
+  - match: '\?.*'
+`, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		"GET /orders/123":         "GET /orders item",
+		"POST /orders/42?force=1": "POST /orders/:id",
+		"GET /orders/7/items":     "GET /orders/:id/items",
+		"Search?q=gatling&page=2": "Search",
+		"Home page":               "Home page",
+	} {
+		if got := requestNames.normalize(name); got != want {
+			t.Errorf("normalize(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestNameRulesErrors(t *testing.T) {
+	defer resetNames()
+
+	if err := loadTestNameRules(t, "rules:\n  - match: '(unclosed'\n", 0); err == nil {
+		t.Error("expected error for invalid regular expression")
+	}
+	resetNames()
+	if err := InitNameRules(filepath.Join(os.TempDir(), "missing-g2i-names.yaml"), 0); err == nil {
+		t.Error("expected error for missing file")
+	}
+	resetNames()
+	if err := InitNameRules("", 0); err != nil || len(nameRules) != 0 {
+		t.Errorf("empty path must mean no rules, got %v and %d rules", err, len(nameRules))
+	}
+}
+
+func TestNamesLimit(t *testing.T) {
+	defer resetNames()
+
+	if err := loadTestNameRules(t, "rules:\n  - match: '\\d+'\n    replace: num\n", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, c := range []struct{ name, want string }{
+		{"page 1", "page num"},
+		{"home", "home"},
+		// Names rewritten to already known ones do not count against the limit
+		{"page 2", "page num"},
+		{"login", otherName},
+		{"search", otherName},
+		{"home", "home"},
+	} {
+		if got := requestNames.normalize(c.name); got != c.want {
+			t.Errorf("%d: normalize(%q) = %q, want %q", i, c.name, got, c.want)
+		}
+	}
+	if requestNames.folded != 2 {
+		t.Errorf("folded %d names, want 2", requestNames.folded)
+	}
+
+	// Each kind of names has its own limit
+	if got := groupNames.normalize("login"); got != "login" {
+		t.Errorf("group name %q is folded", got)
+	}
+}
@@ -29,13 +29,15 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"math/rand"
 	"os"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dakaraj/gatling-to-influxdb/influx"
@@ -48,14 +50,18 @@ import (
 const (
 	oneMillisecond        = 1_000_000
 	simulationLogFileName = "simulation.log"
-	// Constant amounts of elements per log line
-	runLineLen     = 6
-	requestLineLen = 7
-	groupLineLen   = 6
-	userLineLen    = 4
-	errorLineLen   = 3
+	// Constant amount of elements in RUN line, same for all text log versions
+	runLineLen = 6
+	// sequenceWindowMs is how long counters of points per millisecond are kept
+	sequenceWindowMs = 60 * 1000
 )
 
+// seriesSequence is a key for counting points of the same series within the same millisecond
+type seriesSequence struct {
+	series string
+	ms     int64
+}
+
 var (
 	resultDirNamePattern = regexp.MustCompile(`^.+?-(\d{14})\d{3}$`)
 	startTime            = time.Now().Unix()
@@ -65,25 +71,42 @@ var (
 	errStoppedByUser = errors.New("Process stopped by user")
 	errFatal         = errors.New("Fatal error")
 	logDir           string
+	logFile          string
 	testID           string
 	simulationName   string
 	waitTime         uint
+	// lookupInterval is a pause between checks for target directory, results directory and log
+	lookupInterval = 5 * time.Second
+	// oneShot stops parsing on the end of file instead of waiting for new lines
+	oneShot bool
+	// childExited is closed when a wrapped command exits, so its log is complete
+	childExited chan struct{}
+
+	// counters of processed log lines
+	linesParsed uint64
+	linesFailed uint64
+	// parseFailed is set to 1 when log file could not be read at all
+	parseFailed uint32
 
 	tabSep = []byte{9}
 
-	// regular expression patterns for matching log strings
-	userLine    = regexp.MustCompile(`^USER\s`)
-	requestLine = regexp.MustCompile(`^REQUEST\s`)
-	groupLine   = regexp.MustCompile(`GROUP\s`)
-	runLine     = regexp.MustCompile(`^RUN\s`)
-	errorLine   = regexp.MustCompile(`^ERROR\s`)
+	// layout of text log lines, chosen by Gatling version from RUN line
+	layout = gatling34Layout
+	// assertionLines counts assertions declared in text log
+	assertionLines int
+
+	// sequences counts points of the same series within the same millisecond
+	sequences         = make(map[seriesSequence]int64)
+	lastSequenceMs    int64
+	sequencesPrunedMs int64
 
 	parserStopped = make(chan struct{})
+
+	// sendPoint passes points to outputs, tests replace it to inspect created points
+	sendPoint = influx.SendPoint
 )
 
 func lookupTargetDir(ctx context.Context, dir string) error {
-	const loopTimeout = 5 * time.Second
-
 	l.Infoln("Looking for target directory...")
 	for {
 		// This block checks if stop signal is received from user
@@ -99,7 +122,7 @@ func lookupTargetDir(ctx context.Context, dir string) error {
 			return fmt.Errorf("Target path %s exists but there is an error: %w", dir, err)
 		}
 		if os.IsNotExist(err) {
-			time.Sleep(loopTimeout)
+			time.Sleep(lookupInterval)
 			continue
 		}
 
@@ -136,8 +159,6 @@ func walkFunc(path string, info os.FileInfo, err error) error {
 // is parsed and  result timestamp is matched against application start time.
 // Function stops as soon as matched date time is higher then initial one
 func lookupResultsDir(ctx context.Context, dir string) error {
-	const loopTimeout = 5 * time.Second
-
 	l.Infof("Searching for results directory...")
 	for {
 		// This block checks if stop signal is received from user
@@ -156,15 +177,13 @@ func lookupResultsDir(ctx context.Context, dir string) error {
 			return err
 		}
 
-		time.Sleep(loopTimeout)
+		time.Sleep(lookupInterval)
 	}
 
 	return nil
 }
 
 func waitForLog(ctx context.Context) error {
-	const loopTimeout = 5 * time.Second
-
 	l.Infoln("Searching for " + simulationLogFileName + " file...")
 	for {
 		// This block checks if stop signal is received from user
@@ -175,19 +194,21 @@ func waitForLog(ctx context.Context) error {
 		default:
 		}
 
-		fInfo, err := os.Stat(logDir + "/" + simulationLogFileName)
+		logFile = filepath.Join(logDir, simulationLogFileName)
+		fInfo, err := os.Stat(logFile)
 		if err != nil && !os.IsNotExist(err) {
 			return err
 		}
 		if os.IsNotExist(err) {
-			time.Sleep(loopTimeout)
+			time.Sleep(lookupInterval)
 			continue
 		}
 
 		// WARNING: second part of this check may fail on Windows. Not tested
 		if fInfo.Mode().IsRegular() && (runtime.GOOS == "windows" || fInfo.Mode().Perm() == 420) {
-			abs, _ := filepath.Abs(logDir + "/" + simulationLogFileName)
+			abs, _ := filepath.Abs(logFile)
 			l.Infof("Found %s\n", abs)
+			setStateLogFile(abs)
 			break
 		}
 
@@ -197,155 +218,316 @@ func waitForLog(ctx context.Context) error {
 	return nil
 }
 
+// stopAtEOF tells if parser should stop on the end of file instead of waiting for new lines
+func stopAtEOF() bool {
+	if oneShot {
+		return true
+	}
+	select {
+	case <-childExited:
+		return true
+	default:
+		return false
+	}
+}
+
+func timeFromUnix(ms int64) time.Time {
+	return time.Unix(0, ms*oneMillisecond)
+}
+
+// seriesKey builds a key identifying InfluxDB series by measurement name and tags
+func seriesKey(measurement string, tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(measurement)
+	for _, k := range keys {
+		b.WriteByte(',')
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(tags[k])
+	}
+
+	return b.String()
+}
+
+// pointTime returns a unique timestamp for a point of provided series.
+// Gatling logs have millisecond precision, so points of the same series within
+// the same millisecond would overwrite each other in database. Each of them gets
+// its sequence number within the millisecond added as nanoseconds instead.
+// Sequence depends only on the order of log lines, so importing the same log twice
+// yields the same timestamps and overwrites previous data
+func pointTime(measurement string, tags map[string]string, ms int64) time.Time {
+	key := seriesSequence{series: seriesKey(measurement, tags), ms: ms}
+	seq := sequences[key]
+	sequences[key] = seq + 1
+
+	// Counters of old milliseconds are dropped to limit memory usage. Log lines
+	// are written in nearly chronological order, so they are not needed anymore
+	if ms > lastSequenceMs {
+		lastSequenceMs = ms
+	}
+	if lastSequenceMs-sequencesPrunedMs > sequenceWindowMs {
+		for k := range sequences {
+			if k.ms < lastSequenceMs-sequenceWindowMs {
+				delete(sequences, k)
+			}
+		}
+		sequencesPrunedMs = lastSequenceMs
+	}
+
+	return time.Unix(0, ms*oneMillisecond+seq)
+}
+
 func timeFromUnixBytes(ub []byte) (time.Time, error) {
 	timeStamp, err := strconv.ParseInt(string(ub), 10, 64)
 	if err != nil {
 		return time.Time{}, fmt.Errorf("Failed to parse timestamp as integer: %w", err)
 	}
-	// A workaround that adds random amount of microseconds to the timestamp
-	// so db entries will (should) not be overwritten
-	return time.Unix(0, timeStamp*oneMillisecond+rand.Int63n(oneMillisecond)), nil
+
+	return timeFromUnix(timeStamp), nil
 }
 
-func userLineProcess(lb []byte) error {
-	split := bytes.Split(lb, tabSep)
-	if len(split) != userLineLen {
+func userLineProcess(split [][]byte) error {
+	ul := layout.user
+	if len(split) != ul.length {
 		return errors.New("USER line contains unexpected amount of values")
 	}
-	scenario := string(split[1])
-	// Using the second of the two timestamps
-	// A user life duration may come in handy later
-	timestamp, err := timeFromUnixBytes(bytes.TrimSpace(split[3]))
+	scenario := string(split[ul.scenario])
+	status := string(split[ul.status])
+	// User start line uses start timestamp and user end line uses end timestamp
+	tsIndex := ul.end
+	if status == "START" {
+		tsIndex = ul.start
+	}
+	timestamp, err := timeFromUnixBytes(bytes.TrimSpace(split[tsIndex]))
 	if err != nil {
 		return err
 	}
 
-	influx.SendUserLineData(timestamp, scenario, string(split[2]))
+	influx.SendUserLineData(timestamp, scenario, status)
+
+	// Remember user session to enrich requests and groups executed by this user
+	// and to count them
+	if ul.userID < 0 {
+		return nil
+	}
+	userID := string(split[ul.userID])
+	switch status {
+	case "START":
+		userSessions[userID] = &session{scenario: scenario}
+	case "END":
+		s, ok := userSessions[userID]
+		if !ok {
+			return nil
+		}
+		delete(userSessions, userID)
+
+		start, err := strconv.ParseInt(string(split[ul.start]), 10, 64)
+		if err != nil {
+			return fmt.Errorf("Failed to parse user start time in line as integer: %w", err)
+		}
+		end, err := strconv.ParseInt(string(bytes.TrimSpace(split[ul.end])), 10, 64)
+		if err != nil {
+			return fmt.Errorf("Failed to parse user end time in line as integer: %w", err)
+		}
+
+		return sendSessionPoint(s, start, end)
+	}
 
 	return nil
 }
 
-func requestLineProcess(lb []byte) error {
-	split := bytes.Split(lb, tabSep)
-	if len(split) != requestLineLen {
+func requestLineProcess(split [][]byte) error {
+	rl := layout.request
+	if len(split) != rl.length {
 		return errors.New("REQUEST line contains unexpected amount of values")
 	}
 
-// 	userID, err := strconv.ParseInt(string(split[1]), 10, 32)
-// 	if err != nil {
-// 		return fmt.Errorf("Failed to parse userID in line as integer: %w", err)
-// 	}
-	start, err := strconv.ParseInt(string(split[3]), 10, 64)
+	start, err := strconv.ParseInt(string(split[rl.start]), 10, 64)
 	if err != nil {
 		return fmt.Errorf("Failed to parse request start time in line as integer: %w", err)
 	}
-	end, err := strconv.ParseInt(string(split[4]), 10, 64)
+	end, err := strconv.ParseInt(string(split[rl.end]), 10, 64)
 	if err != nil {
 		return fmt.Errorf("Failed to parse request end time in line as integer: %w", err)
 	}
-	timestamp, err := timeFromUnixBytes(split[4])
-	if err != nil {
-		return err
+
+	status := string(split[rl.status])
+	s := userSession(split, rl.userID)
+	if s != nil {
+		s.requests++
+		if status == "KO" {
+			s.koRequests++
+		}
 	}
 
+	return sendRequestPoint(
+		sessionScenario(s),
+		string(split[rl.groups]),
+		string(split[rl.name]),
+		status,
+		string(bytes.TrimSpace(split[rl.message])),
+		start,
+		end,
+	)
+}
+
+// sendRequestPoint creates a point with request data independently of log format
+func sendRequestPoint(scenario, groups, name, result, errorMessage string, start, end int64) error {
+	name = requestNames.normalize(name)
+	if groups != "" {
+		groups = groupNames.normalize(groups)
+	}
+	// Secrets are removed before classification, so they can not get into tags
+	errorMessage = redact(errorMessage)
+	errorClass, statusCode := classifyError(errorMessage)
+	errorMessage = truncate(errorMessage)
+	tags := map[string]string{
+		"scenario":   scenario,
+		"name":       name,
+		"groups":     groups,
+		"result":     result,
+		"errorClass": errorClass,
+		"statusCode": statusCode,
+		"simulation": simulationName,
+		"testId":     testID,
+		"nodeName":   nodeName,
+	}
 	point, err := influx.NewPoint(
 		"requests",
-		map[string]string{
-			"name":       string(split[2]),
-			"groups":     string(split[1]),
-			"result":     string(split[5]),
-			"simulation": simulationName,
-			"testId":     testID,
-			"nodeName":   nodeName,
-		},
+		tags,
 		map[string]interface{}{
 			"duration":     int(end - start),
-			"errorMessage": string(bytes.TrimSpace(split[6])),
+			"errorMessage": errorMessage,
 		},
-		timestamp,
+		pointTime("requests", tags, end),
 	)
 	if err != nil {
 		return fmt.Errorf("Error creating new point with request data: %w", err)
 	}
 
-	influx.SendPoint(point)
+	sendPoint(point)
+	influx.SendRequestData(timeFromUnix(end), name, groups, result, int(end-start))
 
 	return nil
 }
 
-func groupLineProcess(lb []byte) error {
-	split := bytes.Split(lb, tabSep)
-	if len(split) != groupLineLen {
+func groupLineProcess(split [][]byte) error {
+	gl := layout.group
+	if len(split) != gl.length {
 		return errors.New("GROUP line contains unexpected amount of values")
 	}
 
-	start, err := strconv.ParseInt(string(split[2]), 10, 64)
+	start, err := strconv.ParseInt(string(split[gl.start]), 10, 64)
 	if err != nil {
 		return fmt.Errorf("Failed to parse group start time in line as integer: %w", err)
 	}
-	end, err := strconv.ParseInt(string(split[3]), 10, 64)
+	end, err := strconv.ParseInt(string(split[gl.end]), 10, 64)
 	if err != nil {
 		return fmt.Errorf("Failed to parse group end time in line as integer: %w", err)
 	}
-	rawDuration, err := strconv.ParseInt(string(split[4]), 10, 32)
+	rawDuration, err := strconv.ParseInt(string(split[gl.rawDuration]), 10, 32)
 	if err != nil {
 		return fmt.Errorf("Failed to parse group raw duration in line as integer: %w", err)
 	}
-	timestamp, err := timeFromUnixBytes(split[3])
-	if err != nil {
-		return err
+
+	status := string(bytes.TrimSpace(split[gl.status]))
+	s := userSession(split, gl.userID)
+	if s != nil {
+		s.groups++
+		if status == "KO" {
+			s.koGroups++
+		}
 	}
 
+	return sendGroupPoint(sessionScenario(s), string(split[gl.groups]), status, start, end, rawDuration)
+}
+
+// sendGroupPoint creates a point with group data independently of log format
+func sendGroupPoint(scenario, name, result string, start, end, rawDuration int64) error {
+	name = groupNames.normalize(name)
+	tags := map[string]string{
+		"scenario":   scenario,
+		"name":       name,
+		"result":     result,
+		"simulation": simulationName,
+		"testId":     testID,
+		"nodeName":   nodeName,
+	}
 	point, err := influx.NewPoint(
 		"groups",
-		map[string]string{
-			"name":       string(split[1]),
-			"result":     string(split[5][:2]),
-			"simulation": simulationName,
-			"testId":     testID,
-			"nodeName":   nodeName,
-		},
+		tags,
 		map[string]interface{}{
 			"totalDuration": int(end - start),
 			"rawDuration":   int(rawDuration),
 		},
-		timestamp,
+		pointTime("groups", tags, end),
 	)
 	if err != nil {
 		return fmt.Errorf("Error creating new point with group data: %w", err)
 	}
 
-	influx.SendPoint(point)
+	sendPoint(point)
+	influx.SendGroupData(timeFromUnix(end), name, result, int(end-start))
 
 	return nil
 }
 
 // This method should be called first when parsing started as it is based
 // on information from the header row
-func runLineProcess(lb []byte) error {
-	split := bytes.Split(lb, tabSep)
+func runLineProcess(split [][]byte) error {
 	if len(split) != runLineLen {
 		return errors.New("RUN line contains unexpected amount of values")
 	}
 
-	simulationName = string(split[1])
-	description := string(split[4])
-	testStartTime, err := timeFromUnixBytes(split[3])
+	// Gatling 2.x puts record type after simulation class name and simulation ID
+	simulation := string(split[1])
+	if string(split[0]) != "RUN" {
+		simulation = string(split[0])
+	}
+	start, err := strconv.ParseInt(string(split[3]), 10, 64)
 	if err != nil {
-		return err
+		return fmt.Errorf("Failed to parse test start time in line as integer: %w", err)
+	}
+	version := string(bytes.TrimSpace(split[5]))
+
+	layout, err = layoutForVersion(version)
+	if err != nil {
+		l.Errorf("%v. Using layout of Gatling %s", err, layout.name)
+	} else {
+		l.Infof("Gatling version %s detected. Using layout of Gatling %s", version, layout.name)
+	}
+	if layout.request.userID < 0 {
+		warnNoUserIDs("Gatling " + layout.name + " text")
 	}
 
+	return sendRunPoint(simulation, string(split[4]), version, start)
+}
+
+// sendRunPoint initializes test information and creates a point signifying
+// a test start independently of log format
+func sendRunPoint(simulation, description, gatlingVersion string, start int64) error {
+	simulationName = simulation
+	tags := map[string]string{
+		"action":         "start",
+		"simulation":     simulationName,
+		"testId":         testID,
+		"nodeName":       nodeName,
+		"gatlingVersion": gatlingVersion,
+	}
+	testStartTime := pointTime("tests", tags, start)
+
 	// This will initialize required data for influx client
-	influx.InitTestInfo(testID, simulationName, description, nodeName, testStartTime)
+	influx.InitTestInfo(testID, simulationName, description, nodeName, gatlingVersion, testStartTime)
 
 	point, err := influx.NewPoint(
 		"tests",
-		map[string]string{
-			"action":     "start",
-			"simulation": simulationName,
-			"testId":     testID,
-			"nodeName":   nodeName,
-		},
+		tags,
 		map[string]interface{}{
 			"description": description,
 		},
@@ -355,54 +537,87 @@ func runLineProcess(lb []byte) error {
 		return fmt.Errorf("Error creating new point with test start data: %w", err)
 	}
 
-	influx.SendPoint(point)
+	sendPoint(point)
 
 	return nil
 }
 
-func errorLineProcess(lb []byte) error {
-	split := bytes.Split(lb, tabSep)
-	if len(split) != errorLineLen {
+func errorLineProcess(split [][]byte) error {
+	el := layout.error
+	if len(split) != el.length {
 		return errors.New("ERROR line contains unexpected amount of values")
 	}
-	timestamp, err := timeFromUnixBytes(bytes.TrimSpace(split[2]))
+	timestamp, err := strconv.ParseInt(string(bytes.TrimSpace(split[el.timestamp])), 10, 64)
 	if err != nil {
-		return err
+		return fmt.Errorf("Failed to parse error time in line as integer: %w", err)
 	}
 
+	return sendErrorPoint(string(split[el.message]), timestamp)
+}
+
+// sendErrorPoint creates a point with error data independently of log format
+func sendErrorPoint(errorMessage string, timestamp int64) error {
+	errorMessage = redact(errorMessage)
+	errorClass, statusCode := classifyError(errorMessage)
+	errorMessage = truncate(errorMessage)
+	tags := map[string]string{
+		"errorClass": errorClass,
+		"statusCode": statusCode,
+		"testId":     testID,
+		"nodeName":   nodeName,
+		"simulation": simulationName,
+	}
 	point, err := influx.NewPoint(
 		"errors",
-		map[string]string{
-			"testId":     testID,
-			"nodeName":   nodeName,
-			"simulation": simulationName,
-		},
+		tags,
 		map[string]interface{}{
-			"errorMessage": string(split[1]),
+			"errorMessage": errorMessage,
 		},
-		timestamp,
+		pointTime("errors", tags, timestamp),
 	)
 	if err != nil {
 		return fmt.Errorf("Error creating new point with error data: %w", err)
 	}
 
-	influx.SendPoint(point)
+	sendPoint(point)
 
 	return nil
 }
 
+// lineType returns a record type of the log line. Gatling 3.x writes it as the first value,
+// while Gatling 2.x writes it as the third one after scenario name and user ID
+func lineType(split [][]byte) string {
+	for _, i := range []int{0, 2} {
+		if i >= len(split) {
+			break
+		}
+		switch t := string(split[i]); t {
+		case "RUN", "REQUEST", "GROUP", "USER", "ERROR", "ASSERTION":
+			return t
+		}
+	}
+
+	return ""
+}
+
 func stringProcessor(lineBuffer []byte) error {
-	switch {
-	case requestLine.Match(lineBuffer):
-		return requestLineProcess(lineBuffer)
-	case groupLine.Match(lineBuffer):
-		return groupLineProcess(lineBuffer)
-	case userLine.Match(lineBuffer):
-		return userLineProcess(lineBuffer)
-	case errorLine.Match(lineBuffer):
-		return errorLineProcess(lineBuffer)
-	case runLine.Match(lineBuffer):
-		err := runLineProcess(lineBuffer)
+	split := bytes.Split(bytes.TrimRight(lineBuffer, "\r\n"), tabSep)
+	switch lineType(split) {
+	case "REQUEST":
+		return requestLineProcess(split)
+	case "GROUP":
+		return groupLineProcess(split)
+	case "USER":
+		return userLineProcess(split)
+	case "ERROR":
+		return errorLineProcess(split)
+	case "ASSERTION":
+		// Assertion definitions have no results, which are only written to Gatling report
+		assertionLines++
+		influx.SetDeclaredAssertions(assertionLines)
+		return nil
+	case "RUN":
+		err := runLineProcess(split)
 		if err != nil {
 			// Wrapping in a fatal error because further processing is futile
 			err = fmt.Errorf("%v: %w", err, errFatal)
@@ -413,6 +628,23 @@ func stringProcessor(lineBuffer []byte) error {
 	}
 }
 
+// lineProcess processes a single log line and returns true if parsing should be stopped
+func lineProcess(lb []byte) bool {
+	err := stringProcessor(lb)
+	if err != nil {
+		atomic.AddUint64(&linesFailed, 1)
+		l.Errorf("String processing failed: %v", err)
+		if errors.Is(err, errFatal) {
+			l.Errorln("Log parser caught an error that can't be handled. Stopping application...")
+			return true
+		}
+		return false
+	}
+	atomic.AddUint64(&linesParsed, 1)
+
+	return false
+}
+
 func fileProcessor(ctx context.Context, file *os.File) {
 	r := bufio.NewReader(file)
 	buf := new(bytes.Buffer)
@@ -428,15 +660,26 @@ ParseLoop:
 		default:
 		}
 
+		// Completeness of the log is checked before reading, so the end of file reached
+		// after that is the real end of log
+		drain := stopAtEOF()
 		b, err := r.ReadBytes('\n')
 		if err == io.EOF {
+			// All new data is stored in buffer until next loop
+			buf.Write(b)
+			// Complete log may have no line break after the last line, so it is processed as is
+			if drain {
+				if len(bytes.TrimSpace(buf.Bytes())) > 0 {
+					lineProcess(buf.Bytes())
+				}
+				l.Infoln("Reached the end of log file. Processing stopped")
+				break ParseLoop
+			}
 			// If no new lines read for more than value provided by 'stop-timeout' key then processing is stopped
 			if time.Now().After(startWait.Add(time.Duration(waitTime) * time.Second)) {
 				l.Infof("No new lines found for %d seconds. Stopping application...", waitTime)
 				break ParseLoop
 			}
-			// All new data is stored in buffer until next loop
-			buf.Write(b)
 			time.Sleep(time.Second)
 			continue
 		}
@@ -445,13 +688,8 @@ ParseLoop:
 		}
 
 		buf.Write(b)
-		err = stringProcessor(buf.Bytes())
-		if err != nil {
-			l.Errorf("String processing failed: %v", err)
-			if errors.Is(err, errFatal) {
-				l.Errorln("Log parser caught an error that can't be handled. Stopping application...")
-				break ParseLoop
-			}
+		if lineProcess(buf.Bytes()) {
+			break ParseLoop
 		}
 		// Clean buffer after processing preparing for a new loop
 		buf.Reset()
@@ -465,20 +703,45 @@ func parseStart(ctx context.Context, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	l.Infoln("Starting log file parser...")
-	file, err := os.Open(logDir + "/" + simulationLogFileName)
+	file, err := os.Open(logFile)
 	if err != nil {
-		l.Errorf("Failed to read %s file: %v\n", simulationLogFileName, err)
+		l.Errorf("Failed to read %s file: %v\n", logFile, err)
+		atomic.StoreUint32(&parseFailed, 1)
+		parserStopped <- struct{}{}
+		return
 	}
 	defer file.Close()
 
+	isBinary, err := detectBinaryFormat(ctx, file)
+	if err != nil {
+		if err != errStoppedByUser {
+			l.Errorf("Failed to detect format of %s file: %v\n", logFile, err)
+			atomic.StoreUint32(&parseFailed, 1)
+		}
+		parserStopped <- struct{}{}
+		return
+	}
+	if isBinary {
+		l.Infoln("Binary log format detected")
+		binaryFileProcessor(ctx, file)
+		return
+	}
+
 	fileProcessor(ctx, file)
 }
 
 // RunMain performs main application logic
 func RunMain(cmd *cobra.Command, dir string) {
+	if code := runMain(cmd, dir); code != 0 {
+		os.Exit(code)
+	}
+}
+
+// runMain parses the log of the results directory found in provided directory
+// and returns an exit code
+func runMain(cmd *cobra.Command, dir string) int {
 	testID, _ = cmd.Flags().GetString("test-id")
 	waitTime, _ = cmd.Flags().GetUint("stop-timeout")
-	rand.Seed(time.Now().UnixNano())
 	nodeName, _ = os.Hostname()
 
 	l.Infof("Searching for directory at %s", dir)
@@ -486,35 +749,67 @@ func RunMain(cmd *cobra.Command, dir string) {
 	if err != nil {
 		l.Errorf("Failed to construct an absolute path for %s: %v", dir, err)
 	}
+	stopState := startStateWriter(cmd, abs)
 
 	if err := lookupTargetDir(cmd.Context(), abs); err != nil {
+		stopState()
 		if err == errStoppedByUser {
-			return
+			return 0
 		}
 		l.Errorf("Target directory lookup failed with error: %v\n", err)
-		os.Exit(1)
+		return 1
 	}
 
 	if err := lookupResultsDir(cmd.Context(), abs); err != nil {
+		stopState()
 		if err == errStoppedByUser {
-			return
+			return 0
 		}
 		l.Errorf("Error happened while searching for results directory: %v\n", err)
-		os.Exit(1)
+		return 1
 	}
 
 	if err := waitForLog(cmd.Context()); err != nil {
+		stopState()
 		if err == errStoppedByUser {
-			return
+			return 0
 		}
 		l.Errorf("Failed waiting for %s with error: %v\n", simulationLogFileName, err)
-		os.Exit(1)
+		return 1
+	}
+
+	processLog(cmd.Context())
+	stopState()
+
+	writeJUnitReport(cmd, logDir)
+	if err := influx.CheckSLA(); err != nil {
+		l.Errorf("%v\n", err)
+		return 1
 	}
 
+	return 0
+}
+
+// writeJUnitReport writes JUnit report at the end of the test, if it is requested
+func writeJUnitReport(cmd *cobra.Command, resultsDir string) {
+	path, _ := cmd.Flags().GetString("junit-report")
+	if path == "" {
+		return
+	}
+	maxKO, _ := cmd.Flags().GetFloat64("junit-max-ko")
+	if err := influx.WriteJUnitReport(path, resultsDir, maxKO); err != nil {
+		l.Errorf("%v\n", err)
+	}
+}
+
+// processLog starts log parser and points processor and waits
+// until both of them are finished
+func processLog(ctx context.Context) {
 	wg := &sync.WaitGroup{}
 	pCtx, pCancel := context.WithCancel(context.Background())
 	iCtx, iCancel := context.WithCancel(context.Background())
 
+	influx.ResetTestInfo()
 	wg.Add(2)
 	go parseStart(pCtx, wg)
 	go influx.StartProcessing(iCtx, wg)
@@ -523,7 +818,7 @@ FinisherLoop:
 	for {
 		select {
 		// If top level context is cancelled we first stop the parser
-		case <-cmd.Context().Done():
+		case <-ctx.Done():
 			pCancel()
 		// Then wait for parser to stop and stop client processing
 		case <-parserStopped:
@@ -535,3 +830,73 @@ FinisherLoop:
 	}
 	wg.Wait()
 }
+
+// resolveLogFile finds a log file by provided path which can either be a log file
+// itself or a results directory containing simulation.log
+func resolveLogFile(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("Failed to construct an absolute path for %s: %w", path, err)
+	}
+	fInfo, err := os.Stat(abs)
+	if err != nil {
+		return "", err
+	}
+	if fInfo.IsDir() {
+		abs = filepath.Join(abs, simulationLogFileName)
+		fInfo, err = os.Stat(abs)
+		if err != nil {
+			return "", err
+		}
+	}
+	if !fInfo.Mode().IsRegular() {
+		return "", fmt.Errorf("%s is not a regular file", abs)
+	}
+
+	return abs, nil
+}
+
+// RunImport parses an existing log file once from start to end at full speed,
+// sends all points to InfluxDB and exits
+func RunImport(cmd *cobra.Command, path string) {
+	if !importLog(cmd, path) {
+		os.Exit(1)
+	}
+}
+
+// importLog imports a log file and logs a summary of the import. It returns false if the
+// file is not parsed completely, points are not delivered to any output or SLA checks failed
+func importLog(cmd *cobra.Command, path string) bool {
+	testID, _ = cmd.Flags().GetString("test-id")
+	nodeName, _ = os.Hostname()
+	oneShot = true
+	// Log file is already complete, so outputs should not drop points to keep up with parser
+	influx.EnableBackpressure()
+
+	var err error
+	logFile, err = resolveLogFile(path)
+	if err != nil {
+		l.Errorf("Failed to find log file to import: %v\n", err)
+		return false
+	}
+	l.Infof("Importing %s", logFile)
+
+	start := time.Now()
+	processLog(cmd.Context())
+
+	l.Infof("Import finished in %v. Lines parsed: %d, lines failed: %d\n",
+		time.Since(start).Round(time.Millisecond), atomic.LoadUint64(&linesParsed), atomic.LoadUint64(&linesFailed))
+	// Fatal parser errors are counted as failed lines too
+	failed := atomic.LoadUint64(&linesFailed) > 0 || atomic.LoadUint32(&parseFailed) == 1
+	for _, s := range influx.SinksStats() {
+		l.Infof("Output %s: points written: %d, points failed: %d, batches spooled: %d\n", s.Name, s.Written, s.Failed, s.Spooled)
+		failed = failed || s.Failed > 0 || s.Spooled > 0
+	}
+	writeJUnitReport(cmd, filepath.Dir(logFile))
+	if err := influx.CheckSLA(); err != nil {
+		l.Errorf("%v\n", err)
+		failed = true
+	}
+
+	return !failed
+}
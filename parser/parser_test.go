@@ -0,0 +1,205 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package parser
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	l "github.com/dakaraj/gatling-to-influxdb/logger"
+	"github.com/spf13/cobra"
+)
+
+// testLogFile is a path of application log written by tests
+var testLogFile string
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "g2i-test")
+	if err != nil {
+		panic(err)
+	}
+	testLogFile = filepath.Join(dir, "test.log")
+	if err := l.InitLogger(testLogFile); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func resetSequences() {
+	sequences = make(map[seriesSequence]int64)
+	lastSequenceMs = 0
+	sequencesPrunedMs = 0
+}
+
+type pointTimeCall struct {
+	measurement string
+	tags        map[string]string
+	ms          int64
+}
+
+// pointTimeCalls emulates points of a log: several series with many points within the same
+// millisecond, lines slightly out of order and gaps long enough to prune old sequences
+func pointTimeCalls() []pointTimeCall {
+	var calls []pointTimeCall
+	ms := int64(1596196277000)
+	for i := 0; i < 5000; i++ {
+		switch {
+		case i%1000 == 999:
+			ms += 2 * sequenceWindowMs
+		case i%7 == 0:
+			ms++
+		}
+		lineMs := ms
+		if i%11 == 0 {
+			lineMs -= 3
+		}
+		name := []string{"home", "login", "search"}[i%3]
+		calls = append(calls,
+			pointTimeCall{"requests", map[string]string{"name": name, "result": "OK"}, lineMs},
+			pointTimeCall{"requests", map[string]string{"result": "OK", "name": name}, lineMs},
+			pointTimeCall{"errors", map[string]string{"errorClass": "timeout"}, lineMs},
+		)
+	}
+
+	return calls
+}
+
+func TestPointTimeIsDeterministic(t *testing.T) {
+	calls := pointTimeCalls()
+	runs := make([][]time.Time, 2)
+	for r := range runs {
+		resetSequences()
+		for _, c := range calls {
+			runs[r] = append(runs[r], pointTime(c.measurement, c.tags, c.ms))
+		}
+	}
+	defer resetSequences()
+
+	type point struct {
+		series string
+		time   time.Time
+	}
+	seen := make(map[point]bool)
+	for i, c := range calls {
+		if !runs[0][i].Equal(runs[1][i]) {
+			t.Fatalf("call %d: timestamps differ between imports: %v and %v", i, runs[0][i], runs[1][i])
+		}
+		if got := runs[0][i].Truncate(time.Millisecond); got.UnixNano() != c.ms*oneMillisecond {
+			t.Errorf("call %d: timestamp %v is out of millisecond %d", i, runs[0][i], c.ms)
+		}
+		p := point{seriesKey(c.measurement, c.tags), runs[0][i]}
+		if seen[p] {
+			t.Errorf("call %d: duplicate timestamp %v for series %s", i, p.time, p.series)
+		}
+		seen[p] = true
+	}
+}
+
+// runTestImport imports provided log lines as simulation.log of a results directory,
+// returns result of the import and application log written during it
+func runTestImport(t *testing.T, lines ...string) (bool, string) {
+	dir, err := ioutil.TempDir("", "g2i-import")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if lines != nil {
+		content := strings.Join(lines, "\n") + "\n"
+		if err := ioutil.WriteFile(filepath.Join(dir, simulationLogFileName), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	logged, err := ioutil.ReadFile(testLogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	atomic.StoreUint64(&linesParsed, 0)
+	atomic.StoreUint64(&linesFailed, 0)
+	atomic.StoreUint32(&parseFailed, 0)
+	defer func() {
+		oneShot = false
+		layout = gatling34Layout
+		resetSequences()
+	}()
+
+	var ok bool
+	cmd := &cobra.Command{
+		Use: "import",
+		Run: func(cmd *cobra.Command, args []string) {
+			ok = importLog(cmd, dir)
+		},
+	}
+	cmd.Flags().String("test-id", "", "")
+	cmd.Flags().String("junit-report", "", "")
+	cmd.Flags().Float64("junit-max-ko", 0, "")
+	cmd.SetArgs([]string{"--test-id", "import-test"})
+	if err := cmd.ExecuteContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := ioutil.ReadFile(testLogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ok, string(bytes.TrimPrefix(all, logged))
+}
+
+func TestImport(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		lines   []string
+		ok      bool
+		summary string
+	}{
+		{"complete log", []string{
+			"RUN\tcom.example.Search\tsearch\t1596196277000\t \t3.3.1",
+			"USER\tSearch\t1\tSTART\t1596196277000\t1596196277000",
+			"REQUEST\tSearch\t1\t\thome\t1596196277100\t1596196277150\tOK\t ",
+			"USER\tSearch\t1\tEND\t1596196277000\t1596196278000",
+		}, true, "Lines parsed: 4, lines failed: 0"},
+		{"failed line", []string{
+			"RUN\tcom.example.Search\tsearch\t1596196277000\t \t3.3.1",
+			"REQUEST\tSearch\t1\t\thome\tnot-a-time\t1596196277150\tOK\t ",
+		}, false, "Lines parsed: 1, lines failed: 1"},
+		{"missing log", nil, false, "Failed to find log file to import"},
+	} {
+		ok, logged := runTestImport(t, tc.lines...)
+		if ok != tc.ok {
+			t.Errorf("%s: import result %v, want %v", tc.name, ok, tc.ok)
+		}
+		if !strings.Contains(logged, tc.summary) {
+			t.Errorf("%s: summary %q is not logged:\n%s", tc.name, tc.summary, logged)
+		}
+	}
+}
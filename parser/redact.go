@@ -0,0 +1,200 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/dakaraj/gatling-to-influxdb/config"
+	l "github.com/dakaraj/gatling-to-influxdb/logger"
+)
+
+// redactedText replaces secrets matched by rules without own replacement
+const redactedText = "[REDACTED]"
+
+// redactRule replaces parts of error messages matching a regular expression,
+// replacement may refer to submatches like $1. Optional check function
+// filters out matches that are not secrets
+type redactRule struct {
+	Match   string `json:"match"`
+	Replace string `json:"replace"`
+	re      *regexp.Regexp
+	check   func(string) bool
+}
+
+// builtinRedactRules remove common secrets and personal data from error messages
+var builtinRedactRules = []redactRule{
+	{re: regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9\-._~+/]+=*`), Replace: "${1}" + redactedText},
+	{re: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`), Replace: "[JWT]"},
+	{re: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), Replace: "[EMAIL]"},
+	// Timestamps and IDs may look like card numbers too, so matches are checked further
+	{re: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), Replace: "[CARD]", check: cardNumber},
+}
+
+// cardDigits matches numbers of major card networks by their prefixes and lengths: Visa,
+// Mastercard, American Express, Diners Club, Discover, JCB and UnionPay
+var cardDigits = regexp.MustCompile(`^(?:` +
+	`4\d{12}(?:\d{3}|\d{6})?|` +
+	`5[1-5]\d{14}|2(?:22[1-9]|2[3-9]\d|[3-6]\d\d|7[01]\d|720)\d{12}|` +
+	`3[47]\d{13}|` +
+	`3(?:0[0-5]|[68]\d)\d{11,13}|` +
+	`6(?:011|5\d\d|4[4-9]\d)\d{12,15}|` +
+	`35(?:2[89]|[3-8]\d)\d{12,15}|` +
+	`62\d{14,17})$`)
+
+// cardGroups are lengths of digit groups card numbers are written with
+var cardGroups = map[string]bool{
+	"4,4,4,4":   true,
+	"4,4,4,4,1": true,
+	"4,4,4,4,2": true,
+	"4,4,4,4,3": true,
+	"4,6,5":     true,
+	"4,6,4":     true,
+}
+
+var (
+	redactRules = builtinRedactRules
+	// maxErrorLength is a limit of error message length in bytes, zero means no limit
+	maxErrorLength int
+)
+
+// luhnValid checks if digits of a string pass Luhn checksum used by card numbers
+func luhnValid(s string) bool {
+	var sum, n int
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+
+	return n > 0 && sum%10 == 0
+}
+
+// cardGrouped checks if digits of a number are either not separated at all or written
+// in groups of a card number separated by the same character
+func cardGrouped(s string) bool {
+	i := strings.IndexAny(s, " -")
+	if i < 0 {
+		return true
+	}
+	groups := strings.Split(s, s[i:i+1])
+	lengths := make([]string, 0, len(groups))
+	for _, g := range groups {
+		lengths = append(lengths, strconv.Itoa(len(g)))
+	}
+
+	return cardGroups[strings.Join(lengths, ",")]
+}
+
+// cardNumber checks if a number looks like a card number: it is grouped like one,
+// has a prefix and a length of a major card network and passes Luhn check
+func cardNumber(s string) bool {
+	digits := strings.NewReplacer(" ", "", "-", "").Replace(s)
+
+	return cardGrouped(s) && cardDigits.MatchString(digits) && luhnValid(digits)
+}
+
+// InitRedaction loads own redaction rules from a JSON, YAML or TOML file, which are applied
+// after built-in ones, and sets a limit of error message length
+func InitRedaction(path string, maxLength uint) error {
+	maxErrorLength = int(maxLength)
+	if path == "" {
+		return nil
+	}
+
+	var file struct {
+		Rules []redactRule `json:"rules"`
+	}
+	if err := config.DecodeFile(path, &file); err != nil {
+		return fmt.Errorf("Failed to load redaction rules: %w", err)
+	}
+	for i, r := range file.Rules {
+		re, err := regexp.Compile(r.Match)
+		if err != nil {
+			return fmt.Errorf("Redaction rule %d: %w", i+1, err)
+		}
+		r.re = re
+		if r.Replace == "" {
+			r.Replace = redactedText
+		}
+		redactRules = append(redactRules, r)
+	}
+	l.Infof("Loaded %d redaction rules from %s\n", len(file.Rules), path)
+
+	return nil
+}
+
+// redact removes secrets from error message
+func redact(message string) string {
+	if message == "" {
+		return message
+	}
+
+	for _, r := range redactRules {
+		if r.check == nil {
+			message = r.re.ReplaceAllString(message, r.Replace)
+			continue
+		}
+		message = r.re.ReplaceAllStringFunc(message, func(m string) string {
+			if !r.check(m) {
+				return m
+			}
+			return r.re.ReplaceAllString(m, r.Replace)
+		})
+	}
+
+	return message
+}
+
+// truncate cuts error message to the length limit keeping it a valid UTF-8 string.
+// Ellipsis marking the cut fits into the limit too, unless the limit is too small for it
+func truncate(message string) string {
+	const ellipsis = "..."
+	if maxErrorLength == 0 || len(message) <= maxErrorLength {
+		return message
+	}
+
+	cut, suffix := maxErrorLength-len(ellipsis), ellipsis
+	if cut < 0 {
+		cut, suffix = maxErrorLength, ""
+	}
+	for cut > 0 && !utf8.RuneStart(message[cut]) {
+		cut--
+	}
+
+	return message[:cut] + suffix
+}
@@ -0,0 +1,149 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package parser
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncate(t *testing.T) {
+	defer func() { maxErrorLength = 0 }()
+
+	for _, tc := range []struct {
+		limit   int
+		message string
+		want    string
+	}{
+		{0, "no limit at all", "no limit at all"},
+		{10, "short", "short"},
+		{10, "exactly 10", "exactly 10"},
+		{10, "longer than limit", "longer ..."},
+		{10, "ünïcödé text", "ünïc..."},
+		{2, "tiny limit", "ti"},
+	} {
+		maxErrorLength = tc.limit
+		got := truncate(tc.message)
+		if got != tc.want {
+			t.Errorf("truncate(%q) with limit %d = %q, want %q", tc.message, tc.limit, got, tc.want)
+		}
+		if tc.limit > 0 && len(got) > tc.limit {
+			t.Errorf("truncate(%q) = %q exceeds limit %d", tc.message, got, tc.limit)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("truncate(%q) = %q is not valid UTF-8", tc.message, got)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	for _, tc := range []struct {
+		message string
+		want    string
+	}{
+		{"", ""},
+		{"status.find.is(200), but actually found 500", "status.find.is(200), but actually found 500"},
+		{"Authorization: Bearer abc.DEF-123~+/==; retry", "Authorization: Bearer [REDACTED]; retry"},
+		{"token eyJhbGciOiJIUzI1NiJ9.eyJzdWIiOiIxIn0.abc-_123 expired", "token [JWT] expired"},
+		{"user john.doe+test@example.co.uk not found", "user [EMAIL] not found"},
+		// Cards of major networks written the usual ways
+		{"card 4111 1111 1111 1111 declined", "card [CARD] declined"},
+		{"card 4111-1111-1111-1111", "card [CARD]"},
+		{"card 5555555555554444", "card [CARD]"},
+		{"card 2223003122003222", "card [CARD]"},
+		{"card 3782 822463 10005", "card [CARD]"},
+		{"card 3056 930902 5904", "card [CARD]"},
+		{"card 6011111111111117", "card [CARD]"},
+		{"card 3530111333300000", "card [CARD]"},
+		{"card 4222222222222", "card [CARD]"},
+		// Numbers that are not card numbers
+		{"card 4111111111111112", "card 4111111111111112"},
+		{"order 1234567812345670", "order 1234567812345670"},
+		{"card 4111-1111 1111-1111", "card 4111-1111 1111-1111"},
+		{"card 4111 11111111 1111", "card 4111 11111111 1111"},
+		{"phone 7 999 123 45 67 8", "phone 7 999 123 45 67 8"},
+	} {
+		if got := redact(tc.message); got != tc.want {
+			t.Errorf("redact(%q) = %q, want %q", tc.message, got, tc.want)
+		}
+	}
+}
+
+func TestRedactKeepsTimestamps(t *testing.T) {
+	for ms := int64(1596196277000); ms < 1596196278000; ms++ {
+		message := "request timeout at " + strconv.FormatInt(ms, 10)
+		if got := redact(message); got != message {
+			t.Fatalf("redact(%q) = %q", message, got)
+		}
+	}
+}
+
+func TestInitRedaction(t *testing.T) {
+	defer func() {
+		redactRules = builtinRedactRules
+		maxErrorLength = 0
+	}()
+
+	dir, err := ioutil.TempDir("", "g2i-redact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "redact.yaml")
+	doc := `
+rules:
+  - match: '"password":"[^"]*"'
+  - match: 'session=(\w)\w*'
+    replace: 'session=$1***'
+`
+	if err := ioutil.WriteFile(path, []byte(doc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := InitRedaction(path, 40); err != nil {
+		t.Fatal(err)
+	}
+
+	for message, want := range map[string]string{
+		`body {"password":"s3cret"} for a@b.io`: `body {[REDACTED]} for [EMAIL]`,
+		"cookie session=abcdef expired":         "cookie session=a*** expired",
+	} {
+		if got := redact(message); got != want {
+			t.Errorf("redact(%q) = %q, want %q", message, got, want)
+		}
+	}
+	if maxErrorLength != 40 {
+		t.Errorf("max error length = %d, want 40", maxErrorLength)
+	}
+
+	redactRules = builtinRedactRules
+	if err := ioutil.WriteFile(path, []byte("rules:\n  - match: '(unclosed'\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := InitRedaction(path, 0); err == nil {
+		t.Error("expected error for invalid regular expression")
+	}
+}
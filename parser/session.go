@@ -0,0 +1,105 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package parser
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/dakaraj/gatling-to-influxdb/influx"
+	l "github.com/dakaraj/gatling-to-influxdb/logger"
+)
+
+// session keeps counters of an active virtual user. Sessions are only tracked
+// for logs containing user IDs, i.e. written by Gatling versions before 3.4
+type session struct {
+	scenario   string
+	requests   int
+	groups     int
+	koRequests int
+	koGroups   int
+}
+
+var (
+	// userSessions keeps sessions of currently active users by their IDs
+	userSessions = make(map[string]*session)
+	// noUserIDsWarning makes sure missing user IDs are reported only once
+	noUserIDsWarning sync.Once
+)
+
+// warnNoUserIDs reports that log format has no user IDs, so features relying on them
+// silently produce nothing
+func warnNoUserIDs(format string) {
+	noUserIDsWarning.Do(func() {
+		l.Errorf("%s log format has no user IDs, so requests and groups are written without scenario tag and sessions measurement is not written\n", format)
+	})
+}
+
+// userSession returns a session of the user whose ID is found in the line
+// at provided position. Nil is returned if log layout has no user IDs or
+// start of the user was not found in log
+func userSession(split [][]byte, userIDIndex int) *session {
+	if userIDIndex < 0 {
+		return nil
+	}
+
+	return userSessions[string(split[userIDIndex])]
+}
+
+// sessionScenario returns a scenario name of provided session, if any
+func sessionScenario(s *session) string {
+	if s == nil {
+		return ""
+	}
+
+	return s.scenario
+}
+
+// sendSessionPoint creates a point with data of a finished user session
+func sendSessionPoint(s *session, start, end int64) error {
+	tags := map[string]string{
+		"scenario":   s.scenario,
+		"simulation": simulationName,
+		"testId":     testID,
+		"nodeName":   nodeName,
+	}
+	point, err := influx.NewPoint(
+		"sessions",
+		tags,
+		map[string]interface{}{
+			"duration":   int(end - start),
+			"requests":   s.requests,
+			"groups":     s.groups,
+			"koRequests": s.koRequests,
+			"koGroups":   s.koGroups,
+		},
+		pointTime("sessions", tags, end),
+	)
+	if err != nil {
+		return fmt.Errorf("Error creating new point with session data: %w", err)
+	}
+
+	sendPoint(point)
+
+	return nil
+}
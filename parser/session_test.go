@@ -0,0 +1,184 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package parser
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/dakaraj/gatling-to-influxdb/influx"
+	infc "github.com/influxdata/influxdb1-client/v2"
+)
+
+// capturePoints replaces point sending with recording, returned function restores it
+func capturePoints() (*[]*infc.Point, func()) {
+	var points []*infc.Point
+	sendPoint = func(p *infc.Point) {
+		points = append(points, p)
+	}
+
+	return &points, func() {
+		sendPoint = influx.SendPoint
+		userSessions = make(map[string]*session)
+		layout = gatling34Layout
+	}
+}
+
+// processLines processes tab separated lines with provided layout
+func processLines(t *testing.T, ll *lineLayout, lines ...string) {
+	layout = ll
+	for _, line := range lines {
+		split := bytes.Split([]byte(line), tabSep)
+		var err error
+		switch lineType(split) {
+		case "USER":
+			err = userLineProcess(split)
+		case "REQUEST":
+			err = requestLineProcess(split)
+		case "GROUP":
+			err = groupLineProcess(split)
+		default:
+			t.Fatalf("unexpected line %q", line)
+		}
+		if err != nil {
+			t.Fatalf("line %q: %v", line, err)
+		}
+	}
+}
+
+// pointTags returns tags of points of provided measurement in order
+func pointTags(points []*infc.Point, measurement string) []map[string]string {
+	var tags []map[string]string
+	for _, p := range points {
+		if p.Name() == measurement {
+			tags = append(tags, p.Tags())
+		}
+	}
+
+	return tags
+}
+
+func TestScenarioTag(t *testing.T) {
+	points, restore := capturePoints()
+	defer restore()
+
+	processLines(t, gatling30Layout,
+		"USER\tSearch\t1\tSTART\t1596196277000\t1596196277000",
+		"USER\tCheckout\t2\tSTART\t1596196277010\t1596196277010",
+		"REQUEST\tSearch\t1\t\thome\t1596196277100\t1596196277150\tOK\t ",
+		"REQUEST\tCheckout\t2\t\tpay\t1596196277200\t1596196277260\tKO\tstatus.find.is(200), but actually found 500",
+		"GROUP\tCheckout\t2\tbuy\t1596196277200\t1596196277300\t60\tKO",
+		// user whose start is not in the log
+		"REQUEST\tSearch\t3\t\thome\t1596196277400\t1596196277450\tOK\t ",
+	)
+
+	requests := pointTags(*points, "requests")
+	if len(requests) != 3 {
+		t.Fatalf("expected 3 request points, got %d", len(requests))
+	}
+	for i, want := range []string{"Search", "Checkout", ""} {
+		if got := requests[i]["scenario"]; got != want {
+			t.Errorf("request %d: scenario = %q, want %q", i, got, want)
+		}
+	}
+	groups := pointTags(*points, "groups")
+	if len(groups) != 1 || groups[0]["scenario"] != "Checkout" {
+		t.Errorf("unexpected group points %v", groups)
+	}
+}
+
+func TestScenarioTagWithoutUserIDs(t *testing.T) {
+	points, restore := capturePoints()
+	defer restore()
+
+	processLines(t, gatling34Layout,
+		"USER\tSearch\tSTART\t1596196277000",
+		"REQUEST\t\thome\t1596196277100\t1596196277150\tOK\t ",
+	)
+
+	requests := pointTags(*points, "requests")
+	if len(requests) != 1 || requests[0]["scenario"] != "" {
+		t.Errorf("unexpected request points %v", requests)
+	}
+}
+
+func TestSessionPoint(t *testing.T) {
+	points, restore := capturePoints()
+	defer restore()
+
+	processLines(t, gatling2Layout,
+		"Search\t1\tUSER\tSTART\t1596196277000\t1596196277000",
+		"Search\t1\tREQUEST\t\thome\t1596196277100\t1596196277150\tOK\t ",
+		"Search\t1\tREQUEST\tfind\tsearch\t1596196277200\t1596196277260\tKO\tj.n.ConnectException: Connection refused",
+		"Search\t1\tGROUP\tfind\t1596196277200\t1596196277300\t60\tKO",
+		"Search\t1\tREQUEST\t\tlogout\t1596196277400\t1596196277410\tOK\t ",
+		// user ending without start in the log is not reported
+		"Search\t2\tUSER\tEND\t1596196276000\t1596196277500",
+		"Search\t1\tUSER\tEND\t1596196277000\t1596196278000",
+	)
+
+	var sessions []*infc.Point
+	for _, p := range *points {
+		if p.Name() == "sessions" {
+			sessions = append(sessions, p)
+		}
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("expected a single session point, got %d", len(sessions))
+	}
+	if got := sessions[0].Tags()["scenario"]; got != "Search" {
+		t.Errorf("scenario = %q", got)
+	}
+	fields, err := sessions[0].Fields()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int64{"duration": 1000, "requests": 3, "groups": 1, "koRequests": 1, "koGroups": 1}
+	for k, v := range want {
+		if fields[k] != v {
+			t.Errorf("%s = %v, want %d", k, fields[k], v)
+		}
+	}
+	if !sessions[0].Time().Truncate(time.Millisecond).Equal(timeFromUnix(1596196278000)) {
+		t.Errorf("session point time = %v", sessions[0].Time())
+	}
+	if len(userSessions) != 0 {
+		t.Errorf("ended sessions are kept: %v", userSessions)
+	}
+}
+
+func TestNoSessionsWithoutUserIDs(t *testing.T) {
+	points, restore := capturePoints()
+	defer restore()
+
+	processLines(t, gatling34Layout,
+		"USER\tSearch\tSTART\t1596196277000",
+		"REQUEST\t\thome\t1596196277100\t1596196277150\tOK\t ",
+		"USER\tSearch\tEND\t1596196278000",
+	)
+
+	if sessions := pointTags(*points, "sessions"); len(sessions) != 0 {
+		t.Errorf("unexpected session points %v", sessions)
+	}
+}
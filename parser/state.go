@@ -0,0 +1,176 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dakaraj/gatling-to-influxdb/influx"
+	l "github.com/dakaraj/gatling-to-influxdb/logger"
+	"github.com/spf13/cobra"
+)
+
+// stateWriteInterval is how often running process updates its state file
+const stateWriteInterval = 2 * time.Second
+
+// ProcessState is written to a state file by running process, so other g2i
+// processes can report its status and stop it
+type ProcessState struct {
+	PID         int                `json:"pid"`
+	WatchedDir  string             `json:"watchedDir"`
+	LogFile     string             `json:"logFile"`
+	TestID      string             `json:"testId"`
+	StartedAt   time.Time          `json:"startedAt"`
+	UpdatedAt   time.Time          `json:"updatedAt"`
+	Finished    bool               `json:"finished"`
+	LinesParsed uint64             `json:"linesParsed"`
+	LinesFailed uint64             `json:"linesFailed"`
+	Outputs     []influx.SinkStats `json:"outputs"`
+}
+
+var (
+	stateFile string
+	stateMu   sync.Mutex
+	state     ProcessState
+)
+
+// ReadState reads a state file written by g2i process
+func ReadState(path string) (*ProcessState, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &ProcessState{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("Failed to parse state file %s: %w", path, err)
+	}
+
+	return s, nil
+}
+
+// CheckNotRunning returns an error if state file belongs to another running g2i process
+func CheckNotRunning(path string) error {
+	s, err := ReadState(path)
+	if err != nil {
+		// Missing or broken state file does not belong to a running process
+		return nil
+	}
+	if !s.Finished && s.PID != os.Getpid() && ProcessAlive(s.PID) {
+		return fmt.Errorf("Another g2i process with PID %d is using state file %s", s.PID, path)
+	}
+
+	return nil
+}
+
+// setStateLogFile saves a path of the log file being parsed to the state
+func setStateLogFile(path string) {
+	stateMu.Lock()
+	state.LogFile = path
+	stateMu.Unlock()
+}
+
+// writeState saves current state of the process to the state file. Temporary file is renamed
+// after writing, so readers never see a partially written state
+func writeState(finished bool) {
+	if stateFile == "" {
+		return
+	}
+
+	stateMu.Lock()
+	state.UpdatedAt = time.Now()
+	state.Finished = finished
+	state.LinesParsed = atomic.LoadUint64(&linesParsed)
+	state.LinesFailed = atomic.LoadUint64(&linesFailed)
+	state.Outputs = influx.SinksStats()
+	data, err := json.MarshalIndent(state, "", "  ")
+	stateMu.Unlock()
+	if err != nil {
+		l.Errorf("Failed to build state: %v\n", err)
+		return
+	}
+
+	tmp := stateFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		l.Errorf("Failed to write state file: %v\n", err)
+		return
+	}
+	if err := os.Rename(tmp, stateFile); err != nil {
+		l.Errorf("Failed to write state file: %v\n", err)
+	}
+}
+
+// startStateWriter writes state of the process to the file provided by 'state-file' key
+// periodically. Returned function stops writing and saves the final state, so it must be
+// called on every exit path once processing is finished
+func startStateWriter(cmd *cobra.Command, dir string) func() {
+	stateFile, _ = cmd.Flags().GetString("state-file")
+	if stateFile == "" {
+		return func() {}
+	}
+	if err := os.MkdirAll(filepath.Dir(stateFile), 0755); err != nil {
+		l.Errorf("Failed to create state file directory: %v\n", err)
+		stateFile = ""
+		return func() {}
+	}
+
+	state = ProcessState{
+		PID:        os.Getpid(),
+		WatchedDir: dir,
+		TestID:     testID,
+		StartedAt:  time.Now(),
+	}
+	writeState(false)
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(stateWriteInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				writeState(false)
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			// Writer is stopped first, so the final state is never overwritten
+			close(done)
+			<-stopped
+			writeState(true)
+		})
+	}
+}
@@ -0,0 +1,274 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package parser
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// deadPID returns PID of a process that has already exited
+func deadPID(t *testing.T) int {
+	child := exec.Command("sh", "-c", "exit 0")
+	if err := child.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	return child.Process.Pid
+}
+
+// writeTestState writes provided state to a state file in provided directory
+func writeTestState(t *testing.T, dir string, s ProcessState) string {
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "g2i.state.json")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// newStateCmd returns a command with flags used by main and wrap commands, which runs
+// provided function and writes state to provided file
+func newStateCmd(stateFile string, run func(cmd *cobra.Command)) *cobra.Command {
+	cmd := &cobra.Command{
+		Use: "g2i",
+		Run: func(cmd *cobra.Command, args []string) {
+			run(cmd)
+		},
+	}
+	cmd.Flags().String("test-id", "", "")
+	cmd.Flags().String("state-file", "", "")
+	cmd.Flags().Uint("stop-timeout", 1, "")
+	cmd.Flags().String("junit-report", "", "")
+	cmd.Flags().Float64("junit-max-ko", 0, "")
+	cmd.SetArgs([]string{"--test-id", "state-test", "--state-file", stateFile})
+
+	return cmd
+}
+
+func TestCheckNotRunning(t *testing.T) {
+	dir, err := ioutil.TempDir("", "g2i-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	running := exec.Command("sleep", "30")
+	if err := running.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		running.Process.Kill()
+		running.Wait()
+	}()
+
+	for _, tc := range []struct {
+		name    string
+		state   ProcessState
+		running bool
+	}{
+		{"stale state of dead process", ProcessState{PID: deadPID(t)}, false},
+		{"another running process", ProcessState{PID: running.Process.Pid}, true},
+		{"finished process", ProcessState{PID: running.Process.Pid, Finished: true}, false},
+		{"own state", ProcessState{PID: os.Getpid()}, false},
+	} {
+		path := writeTestState(t, dir, tc.state)
+		if err := CheckNotRunning(path); (err != nil) != tc.running {
+			t.Errorf("%s: CheckNotRunning returned %v, running %v", tc.name, err, tc.running)
+		}
+	}
+
+	if err := CheckNotRunning(filepath.Join(dir, "missing.json")); err != nil {
+		t.Errorf("missing state file: CheckNotRunning returned %v", err)
+	}
+	broken := filepath.Join(dir, "broken.json")
+	if err := ioutil.WriteFile(broken, []byte(`{"pid":`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckNotRunning(broken); err != nil {
+		t.Errorf("broken state file: CheckNotRunning returned %v", err)
+	}
+}
+
+func TestStateWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "g2i-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "log", "g2i.state.json")
+
+	cmd := newStateCmd(path, func(cmd *cobra.Command) {
+		testID, _ = cmd.Flags().GetString("test-id")
+		stopState := startStateWriter(cmd, dir)
+
+		s, err := ReadState(path)
+		if err != nil {
+			t.Fatalf("state is not written at start: %v", err)
+		}
+		if s.PID != os.Getpid() || s.WatchedDir != dir || s.TestID != "state-test" || s.Finished {
+			t.Errorf("state at start %+v does not describe running process", s)
+		}
+
+		logPath := filepath.Join(dir, simulationLogFileName)
+		setStateLogFile(logPath)
+		stopState()
+		stopState()
+
+		s, err = ReadState(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !s.Finished || s.LogFile != logPath || s.UpdatedAt.Before(s.StartedAt) {
+			t.Errorf("final state %+v is not finished or misses log file", s)
+		}
+	})
+	if err := cmd.ExecuteContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFinalStateOnExit(t *testing.T) {
+	defer func(v time.Duration) { lookupInterval = v }(lookupInterval)
+	lookupInterval = 100 * time.Millisecond
+	defer func(v uint) { waitTime = v }(waitTime)
+	defer func() {
+		childExited = nil
+		layout = gatling34Layout
+		resetSequences()
+	}()
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, tc := range []struct {
+		name  string
+		ctx   context.Context
+		setup func(t *testing.T, dir string) string
+		run   func(cmd *cobra.Command, target string) int
+		code  int
+	}{
+		{
+			name: "stopped by user",
+			ctx:  cancelled,
+			setup: func(t *testing.T, dir string) string {
+				return filepath.Join(dir, "target")
+			},
+			run:  runMain,
+			code: 0,
+		},
+		{
+			name: "lookup failed",
+			ctx:  context.Background(),
+			setup: func(t *testing.T, dir string) string {
+				target := filepath.Join(dir, "target")
+				if err := ioutil.WriteFile(target, nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return target
+			},
+			run:  runMain,
+			code: 1,
+		},
+		{
+			name: "log parsed",
+			ctx:  context.Background(),
+			setup: func(t *testing.T, dir string) string {
+				target := filepath.Join(dir, "target")
+				results := filepath.Join(target, "mysimulation-29991231235959000")
+				if err := os.MkdirAll(results, 0755); err != nil {
+					t.Fatal(err)
+				}
+				logPath := filepath.Join(results, simulationLogFileName)
+				content := "RUN\tcom.example.Search\tsearch\t1596196277000\t \t3.3.1\n"
+				if err := ioutil.WriteFile(logPath, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chmod(logPath, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return target
+			},
+			run:  runMain,
+			code: 0,
+		},
+		{
+			name: "wrapped command not started",
+			ctx:  context.Background(),
+			setup: func(t *testing.T, dir string) string {
+				return filepath.Join(dir, "target")
+			},
+			run: func(cmd *cobra.Command, target string) int {
+				return runWrap(cmd, target, []string{filepath.Join(target, "g2i-missing-command")})
+			},
+			code: 1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "g2i-state")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "g2i.state.json")
+			target := tc.setup(t, dir)
+			atomic.StoreUint64(&linesParsed, 0)
+			atomic.StoreUint64(&linesFailed, 0)
+
+			var code int
+			cmd := newStateCmd(path, func(cmd *cobra.Command) {
+				code = tc.run(cmd, target)
+			})
+			if err := cmd.ExecuteContext(tc.ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			if code != tc.code {
+				t.Errorf("exit code %d, want %d", code, tc.code)
+			}
+			s, err := ReadState(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !s.Finished {
+				t.Errorf("final state %+v is not finished", s)
+			}
+			if err := CheckNotRunning(path); err != nil {
+				t.Errorf("final state blocks next start: %v", err)
+			}
+		})
+	}
+}
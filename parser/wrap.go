@@ -0,0 +1,189 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package parser
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/dakaraj/gatling-to-influxdb/influx"
+	l "github.com/dakaraj/gatling-to-influxdb/logger"
+	"github.com/spf13/cobra"
+)
+
+// lookupGracePeriod is a time given to find results of a wrapped command after it exits.
+// Lookup functions check directories every lookup interval, so it covers a couple of checks
+var lookupGracePeriod = 3 * lookupInterval
+
+// lookupLog searches for the target directory, results directory and log file
+func lookupLog(ctx context.Context, abs string) error {
+	if err := lookupTargetDir(ctx, abs); err != nil {
+		return err
+	}
+	if err := lookupResultsDir(ctx, abs); err != nil {
+		return err
+	}
+
+	return waitForLog(ctx)
+}
+
+// exitCode returns an exit code of finished command
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() >= 0 {
+		return exitErr.ExitCode()
+	}
+
+	return 1
+}
+
+// wrapExitCode returns an exit code of wrap command, which is the child exit code,
+// or 1 if the child succeeded but SLA check failed
+func wrapExitCode(childErr, slaErr error) int {
+	code := exitCode(childErr)
+	if slaErr != nil && code == 0 {
+		return 1
+	}
+
+	return code
+}
+
+// awaitLookup waits for the log lookup result. Once the child exits, lookup is given a grace
+// period to find results written right before exit and is cancelled after that
+func awaitLookup(lookupDone <-chan error, exited <-chan struct{}, cancel context.CancelFunc) error {
+	select {
+	case err := <-lookupDone:
+		return err
+	case <-exited:
+		// Results may appear right before the child exits, so lookup gets a chance to find them
+		select {
+		case err := <-lookupDone:
+			return err
+		case <-time.After(lookupGracePeriod):
+			cancel()
+			return <-lookupDone
+		}
+	}
+}
+
+// RunWrap starts provided command as a child process streaming its output, parses the log
+// of simulation it runs until the child exits and the log is fully read, then exits with
+// the child exit code, or with 1 if the child succeeded but SLA check failed
+func RunWrap(cmd *cobra.Command, dir string, command []string) {
+	os.Exit(runWrap(cmd, dir, command))
+}
+
+// runWrap runs provided command with parser and returns an exit code of wrap command
+func runWrap(cmd *cobra.Command, dir string, command []string) int {
+	testID, _ = cmd.Flags().GetString("test-id")
+	nodeName, _ = os.Hostname()
+	// Parsing is stopped by child exit instead of timeout, so long pauses of simulation are fine
+	waitTime = math.MaxInt32
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		l.Errorf("Failed to construct an absolute path for %s: %v", dir, err)
+		return 1
+	}
+	stopState := startStateWriter(cmd, abs)
+
+	child := exec.Command(command[0], command[1:]...)
+	child.Stdin = os.Stdin
+	child.Stdout = os.Stdout
+	child.Stderr = os.Stderr
+	l.Infof("Starting %v\n", command)
+	if err := child.Start(); err != nil {
+		l.Errorf("Failed to start %s: %v\n", command[0], err)
+		stopState()
+		return 1
+	}
+
+	exited := make(chan struct{})
+	var childErr error
+	go func() {
+		childErr = child.Wait()
+		close(exited)
+	}()
+
+	// Stop signals received by application are passed to the child. Parser is not stopped
+	// by them, as the child may still be writing its log, so it runs until the child exits
+	go func() {
+		select {
+		case <-cmd.Context().Done():
+		case <-exited:
+			return
+		}
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		defer signal.Stop(sigs)
+		for {
+			l.Infoln("Passing stop signal to child process")
+			child.Process.Signal(os.Interrupt)
+			select {
+			case <-sigs:
+			case <-exited:
+				return
+			}
+		}
+	}()
+
+	lookupCtx, lookupCancel := context.WithCancel(context.Background())
+	lookupDone := make(chan error, 1)
+	go func() {
+		lookupDone <- lookupLog(lookupCtx, abs)
+	}()
+
+	lookupErr := awaitLookup(lookupDone, exited, lookupCancel)
+	lookupCancel()
+
+	if lookupErr == nil {
+		childExited = exited
+		processLog(context.Background())
+	} else if lookupErr != errStoppedByUser {
+		l.Errorf("Failed to find simulation log: %v\n", lookupErr)
+	} else {
+		l.Errorln("Simulation log was not found before child process exited")
+	}
+
+	<-exited
+	stopState()
+	l.Infof("Child process exited with code %d\n", exitCode(childErr))
+
+	writeJUnitReport(cmd, logDir)
+	slaErr := influx.CheckSLA()
+	if slaErr != nil {
+		l.Errorf("%v\n", slaErr)
+	}
+
+	return wrapExitCode(childErr, slaErr)
+}
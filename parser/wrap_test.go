@@ -0,0 +1,210 @@
+/*
+Copyright © 2020 Anton Kramarev
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package parser
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func TestWrapExitCode(t *testing.T) {
+	failed := exec.Command("sh", "-c", "exit 3").Run()
+	notStarted := exec.Command(filepath.Join(os.TempDir(), "g2i-missing-command")).Run()
+	slaErr := errors.New("1 of 1 SLA checks failed")
+
+	for _, tc := range []struct {
+		name     string
+		childErr error
+		slaErr   error
+		want     int
+	}{
+		{"success", nil, nil, 0},
+		{"SLA failed", nil, slaErr, 1},
+		{"child failed", failed, nil, 3},
+		{"child and SLA failed", failed, slaErr, 3},
+		{"child not started", notStarted, nil, 1},
+	} {
+		if got := wrapExitCode(tc.childErr, tc.slaErr); got != tc.want {
+			t.Errorf("%s: exit code %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestAwaitLookup(t *testing.T) {
+	defer func(v time.Duration) { lookupGracePeriod = v }(lookupGracePeriod)
+	lookupGracePeriod = 100 * time.Millisecond
+
+	exited := make(chan struct{})
+	lookupDone := make(chan error, 1)
+	lookupDone <- nil
+	if err := awaitLookup(lookupDone, exited, func() { t.Error("lookup is cancelled") }); err != nil {
+		t.Errorf("lookup result %v, want nil", err)
+	}
+
+	// Results found within grace period after the child exits are used
+	close(exited)
+	go func() {
+		time.Sleep(lookupGracePeriod / 4)
+		lookupDone <- nil
+	}()
+	if err := awaitLookup(lookupDone, exited, func() { t.Error("lookup is cancelled") }); err != nil {
+		t.Errorf("lookup result within grace period %v, want nil", err)
+	}
+
+	// Lookup is cancelled after grace period
+	start := time.Now()
+	cancel := func() { lookupDone <- errStoppedByUser }
+	if err := awaitLookup(lookupDone, exited, cancel); err != errStoppedByUser {
+		t.Errorf("lookup result after grace period %v, want %v", err, errStoppedByUser)
+	}
+	if d := time.Since(start); d < lookupGracePeriod {
+		t.Errorf("lookup is cancelled after %v, before grace period", d)
+	}
+}
+
+func TestParserDrainsLogAfterChildExit(t *testing.T) {
+	_, restore := capturePoints()
+	defer restore()
+	defer func(v uint) { waitTime = v }(waitTime)
+	waitTime = 3600
+	childExited = make(chan struct{})
+	defer func() { childExited = nil }()
+	atomic.StoreUint64(&linesParsed, 0)
+	atomic.StoreUint64(&linesFailed, 0)
+
+	dir, err := ioutil.TempDir("", "g2i-drain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, simulationLogFileName)
+	line := "REQUEST\t\thome\t1596196277100\t1596196277150\tOK\t \n"
+	if err := ioutil.WriteFile(path, []byte(line+line), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	go fileProcessor(context.Background(), file)
+
+	// Parser waits for new lines at the end of file while the child is running
+	select {
+	case <-parserStopped:
+		t.Fatal("parser stopped before child exited")
+	case <-time.After(1500 * time.Millisecond):
+	}
+
+	// Lines written right before exit are parsed, including the last one without line break
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(line + line[:len(line)-1])
+	f.Close()
+	close(childExited)
+
+	select {
+	case <-parserStopped:
+	case <-time.After(10 * time.Second):
+		t.Fatal("parser did not stop after child exited")
+	}
+	if parsed := atomic.LoadUint64(&linesParsed); parsed != 4 {
+		t.Errorf("parsed %d lines, want 4", parsed)
+	}
+}
+
+func TestRunWrap(t *testing.T) {
+	defer func(v time.Duration) { lookupInterval = v }(lookupInterval)
+	lookupInterval = 100 * time.Millisecond
+	defer func(v uint) { waitTime = v }(waitTime)
+	defer func() {
+		childExited = nil
+		layout = gatling34Layout
+		resetSequences()
+	}()
+	atomic.StoreUint64(&linesParsed, 0)
+	atomic.StoreUint64(&linesFailed, 0)
+
+	dir, err := ioutil.TempDir("", "g2i-wrap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "target")
+
+	// Child creates results directory, writes its log and fails like a simulation with failed assertions
+	script := `umask 022
+results="$1/mysimulation-29991231235959000"
+mkdir -p "$results"
+printf 'RUN\tcom.example.Search\tsearch\t1596196277000\t \t3.3.1\n' > "$results/simulation.log"
+sleep 1
+printf 'USER\tSearch\t1\tSTART\t1596196277000\t1596196277000\n' >> "$results/simulation.log"
+printf 'REQUEST\tSearch\t1\t\thome\t1596196277100\t1596196277150\tOK\t ' >> "$results/simulation.log"
+echo "simulation finished"
+exit 2`
+
+	var code int
+	cmd := &cobra.Command{
+		Use: "wrap",
+		Run: func(cmd *cobra.Command, args []string) {
+			code = runWrap(cmd, target, []string{"sh", "-c", script, "sh", target})
+		},
+	}
+	cmd.Flags().String("test-id", "", "")
+	cmd.Flags().String("state-file", "", "")
+	cmd.Flags().String("junit-report", "", "")
+	cmd.Flags().Float64("junit-max-ko", 0, "")
+	cmd.SetArgs([]string{"--test-id", "wrap-test"})
+
+	done := make(chan struct{})
+	go func() {
+		if err := cmd.ExecuteContext(context.Background()); err != nil {
+			t.Error(err)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("wrap did not finish after child exited")
+	}
+
+	if code != 2 {
+		t.Errorf("exit code %d, want exit code 2 of child", code)
+	}
+	if parsed, failed := atomic.LoadUint64(&linesParsed), atomic.LoadUint64(&linesFailed); parsed != 3 || failed != 0 {
+		t.Errorf("parsed %d lines and failed %d, want all 3 lines of log parsed", parsed, failed)
+	}
+}